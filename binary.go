@@ -0,0 +1,349 @@
+package memcached
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	binaryRequestMagic  byte = 0x80
+	binaryResponseMagic byte = 0x81
+	binaryHeaderLen          = 24
+)
+
+const (
+	opGet      byte = 0x00
+	opSet      byte = 0x01
+	opDelete   byte = 0x04
+	opGetK     byte = 0x0c
+	opGetKQ    byte = 0x0d
+	opSASLAuth byte = 0x21
+)
+
+const (
+	statusSuccess     uint16 = 0x0000
+	statusKeyNotFound uint16 = 0x0001
+	statusKeyExists   uint16 = 0x0002
+	statusNotStored   uint16 = 0x0005
+	statusAuthFailed  uint16 = 0x0020
+)
+
+// MemcachedBinary honours the key prefix, key length, value size and
+// compression options of the text client. Keys may hold any bytes.
+type MemcachedBinary struct {
+	m *Memcached
+}
+
+func NewMemcachedBinary(network string, address string, opts ...Option) (*MemcachedBinary, error) {
+	m, err := NewMemcached(network, address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &MemcachedBinary{m: m}, nil
+}
+
+// NewMemcachedBinarySASL logs in with SASL PLAIN on every new connection
+// before any command is sent. A server started with -S only accepts the
+// binary protocol, which is why the text client has no SASL constructor.
+func NewMemcachedBinarySASL(network string, address string, username string, password string, opts ...Option) (*MemcachedBinary, error) {
+	factory := func() Transport {
+		return NewTransportSocketSASL(network, address, username, password)
+	}
+	return &MemcachedBinary{m: newMemcached(address, 1, factory, opts)}, nil
+}
+
+func (b *MemcachedBinary) Set(key Key, value string, ttl TTL) error {
+	key, validKeyErr := b.validKey(key)
+	if validKeyErr != nil {
+		return validKeyErr
+	}
+	validTtlErr := ttl.isValid()
+	if validTtlErr != nil {
+		return validTtlErr
+	}
+	value, flags, encodeErr := b.m.encodeValue(value, 0)
+	if encodeErr != nil {
+		return encodeErr
+	}
+	validSizeErr := b.m.validValueSize(value)
+	if validSizeErr != nil {
+		return validSizeErr
+	}
+
+	extras := make([]byte, 8)
+	binary.BigEndian.PutUint32(extras[0:4], flags)
+	binary.BigEndian.PutUint32(extras[4:8], uint32(ttl))
+	req := &binaryPacket{magic: binaryRequestMagic, opcode: opSet, extras: extras, key: []byte(key), value: []byte(value)}
+	_, err := b.request(req)
+	return err
+}
+
+func (b *MemcachedBinary) Get(key Key) (string, error) {
+	key, validKeyErr := b.validKey(key)
+	if validKeyErr != nil {
+		return "", validKeyErr
+	}
+
+	req := &binaryPacket{magic: binaryRequestMagic, opcode: opGet, key: []byte(key)}
+	resp, err := b.request(req)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	value, _, err := decodeBinaryValue(resp)
+	return value, err
+}
+
+// GetMulti sends GETKQ for all keys but the last one and GETK for the last.
+// Only hits answer GETKQ, so the GETK reply ends the batch, and the values
+// are matched by the key the server returns with them.
+func (b *MemcachedBinary) GetMulti(keys []Key) (map[Key]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	var reqs []byte
+	for i, key := range keys {
+		key, validKeyErr := b.validKey(key)
+		if validKeyErr != nil {
+			return nil, validKeyErr
+		}
+		opcode := opGetKQ
+		if i == len(keys)-1 {
+			opcode = opGetK
+		}
+		reqs = append(reqs, (&binaryPacket{magic: binaryRequestMagic, opcode: opcode, key: []byte(key)}).encode()...)
+	}
+
+	values := make(map[Key]string, len(keys))
+	err := b.m.exec(context.Background(), binaryOpName(opGetK), func(t Transport) error {
+		connectErr := t.Connect()
+		if connectErr != nil {
+			return connectErr
+		}
+		writeErr := t.Write(string(reqs))
+		if writeErr == nil {
+			writeErr = t.Flush()
+		}
+		if writeErr != nil {
+			t.Close()
+			return fmt.Errorf("write error: %w\n", writeErr)
+		}
+		for {
+			resp, readErr := readBinaryPacket(t)
+			if readErr != nil {
+				t.Close()
+				return fmt.Errorf("read error: %w\n", readErr)
+			}
+			statusErr := statusError(resp)
+			if statusErr == nil {
+				value, _, decodeErr := decodeBinaryValue(resp)
+				if decodeErr != nil {
+					return decodeErr
+				}
+				values[Key(strings.TrimPrefix(string(resp.key), b.m.prefix))] = value
+			} else if !errors.Is(statusErr, ErrNotFound) {
+				return statusErr
+			}
+			if resp.opcode == opGetK {
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (b *MemcachedBinary) Delete(key Key) error {
+	key, validKeyErr := b.validKey(key)
+	if validKeyErr != nil {
+		return validKeyErr
+	}
+
+	req := &binaryPacket{magic: binaryRequestMagic, opcode: opDelete, key: []byte(key)}
+	_, err := b.request(req)
+	return err
+}
+
+func (b *MemcachedBinary) Close() {
+	b.m.Close()
+}
+
+func (b *MemcachedBinary) request(req *binaryPacket) (*binaryPacket, error) {
+	var resp *binaryPacket
+	err := b.m.exec(context.Background(), binaryOpName(req.opcode), func(t Transport) error {
+		var err error
+		resp, err = binaryRoundTrip(t, req)
+		if err != nil {
+			return err
+		}
+		return statusError(resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func binaryRoundTrip(t Transport, req *binaryPacket) (*binaryPacket, error) {
+	connectErr := t.Connect()
+	if connectErr != nil {
+		return nil, connectErr
+	}
+	writeErr := t.Write(string(req.encode()))
+	if writeErr == nil {
+		writeErr = t.Flush()
+	}
+	if writeErr != nil {
+		t.Close()
+		return nil, fmt.Errorf("write error: %w\n", writeErr)
+	}
+	resp, readErr := readBinaryPacket(t)
+	if readErr != nil {
+		t.Close()
+		return nil, fmt.Errorf("read error: %w\n", readErr)
+	}
+	return resp, nil
+}
+
+func binaryOpName(opcode byte) string {
+	switch opcode {
+	case opGet:
+		return "get"
+	case opSet:
+		return "set"
+	case opDelete:
+		return "delete"
+	case opGetK, opGetKQ:
+		return "getk"
+	}
+	return fmt.Sprintf("0x%02x", opcode)
+}
+
+func statusError(resp *binaryPacket) error {
+	switch resp.status {
+	case statusSuccess:
+		return nil
+	case statusKeyNotFound:
+		return ErrNotFound
+	case statusKeyExists:
+		return ErrCASConflict
+	case statusNotStored:
+		return ErrNotStored
+	case statusAuthFailed:
+		return ErrAuthFailed
+	}
+	return fmt.Errorf("status 0x%04x: %q: %w", resp.status, resp.value, ErrServerError)
+}
+
+func (b *MemcachedBinary) validKey(key Key) (Key, error) {
+	key = Key(b.m.prefix) + key
+	return key, validBinaryKey(key, b.m.maxKeyLength)
+}
+
+func validBinaryKey(key Key, maxLength int) error {
+	if len(key) == 0 {
+		return fmt.Errorf("empty key: %w", ErrInvalidArgument)
+	}
+	if len(key) > maxLength {
+		return fmt.Errorf("key too long: %w", ErrInvalidArgument)
+	}
+	return nil
+}
+
+// decodeBinaryValue reads the client flags from the extras of a get reply.
+func decodeBinaryValue(resp *binaryPacket) (string, uint32, error) {
+	var flags uint32
+	if len(resp.extras) >= 4 {
+		flags = binary.BigEndian.Uint32(resp.extras[0:4])
+	}
+	return decodeValue(string(resp.value), flags)
+}
+
+type binaryPacket struct {
+	magic    byte
+	opcode   byte
+	dataType byte
+	status   uint16
+	opaque   uint32
+	cas      uint64
+	extras   []byte
+	key      []byte
+	value    []byte
+}
+
+func (p *binaryPacket) encode() []byte {
+	bodyLen := len(p.extras) + len(p.key) + len(p.value)
+	buf := make([]byte, binaryHeaderLen+bodyLen)
+	buf[0] = p.magic
+	buf[1] = p.opcode
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(p.key)))
+	buf[4] = byte(len(p.extras))
+	buf[5] = p.dataType
+	binary.BigEndian.PutUint16(buf[6:8], p.status)
+	binary.BigEndian.PutUint32(buf[8:12], uint32(bodyLen))
+	binary.BigEndian.PutUint32(buf[12:16], p.opaque)
+	binary.BigEndian.PutUint64(buf[16:24], p.cas)
+	n := binaryHeaderLen
+	n += copy(buf[n:], p.extras)
+	n += copy(buf[n:], p.key)
+	copy(buf[n:], p.value)
+	return buf
+}
+
+func decodeBinaryHeader(header []byte) (*binaryPacket, int, int, int, error) {
+	if len(header) != binaryHeaderLen || header[0] != binaryResponseMagic {
+		return nil, 0, 0, 0, fmt.Errorf("cannot parse binary header: %q\n", header)
+	}
+	p := &binaryPacket{
+		magic:    header[0],
+		opcode:   header[1],
+		dataType: header[5],
+		status:   binary.BigEndian.Uint16(header[6:8]),
+		opaque:   binary.BigEndian.Uint32(header[12:16]),
+		cas:      binary.BigEndian.Uint64(header[16:24]),
+	}
+	keyLen := int(binary.BigEndian.Uint16(header[2:4]))
+	extrasLen := int(header[4])
+	bodyLen := int(binary.BigEndian.Uint32(header[8:12]))
+	if extrasLen+keyLen > bodyLen {
+		return nil, 0, 0, 0, fmt.Errorf("cannot parse binary header: %q\n", header)
+	}
+	return p, extrasLen, keyLen, bodyLen, nil
+}
+
+func readBinaryPacket(t Transport) (*binaryPacket, error) {
+	header, err := t.ReadN(binaryHeaderLen)
+	if err != nil {
+		return nil, err
+	}
+	p, extrasLen, keyLen, bodyLen, err := decodeBinaryHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	if bodyLen > 0 {
+		body, err := t.ReadN(bodyLen)
+		if err != nil {
+			return nil, err
+		}
+		p.extras = body[:extrasLen]
+		p.key = body[extrasLen : extrasLen+keyLen]
+		p.value = body[extrasLen+keyLen:]
+	}
+	return p, nil
+}
+
+func saslPlainRequest(username string, password string) *binaryPacket {
+	return &binaryPacket{
+		magic:  binaryRequestMagic,
+		opcode: opSASLAuth,
+		key:    []byte("PLAIN"),
+		value:  []byte("\x00" + username + "\x00" + password),
+	}
+}
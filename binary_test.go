@@ -0,0 +1,232 @@
+package memcached
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestSASLPlainRequest(t *testing.T) {
+	want := []byte{
+		0x80, 0x21, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x11, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		'P', 'L', 'A', 'I', 'N',
+		0x00, 'u', 's', 'e', 'r', 0x00, 's', 'e', 'c', 'r', 'e', 't',
+	}
+	if got := saslPlainRequest("user", "secret").encode(); !bytes.Equal(got, want) {
+		t.Fatalf("SASL PLAIN frame = % x, want % x", got, want)
+	}
+}
+
+func TestBinarySASL(t *testing.T) {
+	srv := newMemServer()
+	srv.saslUser, srv.saslPass = "user", "secret"
+	addr := serveTCP(t, srv)
+
+	b, err := NewMemcachedBinarySASL("tcp", addr, "user", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.Set("k", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := b.Get("k"); err != nil || value != "v" {
+		t.Fatalf("Get after SASL = %q, %v", value, err)
+	}
+	if got := srv.received(); len(got) != 3 || got[0] != "0x21 PLAIN" {
+		t.Fatalf("server received %q, want the SASL auth first", got)
+	}
+
+	wrong, _ := NewMemcachedBinarySASL("tcp", addr, "user", "wrong")
+	defer wrong.Close()
+	for i := 0; i < 2; i++ {
+		if err := wrong.Set("k", "v", 0); !errors.Is(err, ErrAuthFailed) {
+			t.Fatalf("Set with a wrong password = %v, want ErrAuthFailed", err)
+		}
+	}
+	if got := srv.conns.Load(); got != 3 {
+		t.Fatalf("connections = %d, want a failed login to close its connection", got)
+	}
+}
+
+func TestTextClientRejectedBySASLServer(t *testing.T) {
+	srv := newMemServer()
+	srv.saslUser, srv.saslPass = "user", "secret"
+	m, err := NewMemcached("tcp", serveTCP(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err := m.Set("k", "v", 0); !errors.Is(err, ErrServerError) {
+		t.Fatalf("text Set against a SASL server = %v, want a server error", err)
+	}
+}
+
+func TestBinaryPacketEncode(t *testing.T) {
+	extras := []byte{0xde, 0xad, 0xbe, 0xef, 0x00, 0x00, 0x0e, 0x10}
+	req := &binaryPacket{magic: binaryRequestMagic, opcode: opSet, opaque: 7, extras: extras, key: []byte("Hello"), value: []byte("World")}
+	want := []byte{
+		0x80, 0x01, 0x00, 0x05, 0x08, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x12, 0x00, 0x00, 0x00, 0x07,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xde, 0xad, 0xbe, 0xef, 0x00, 0x00, 0x0e, 0x10,
+		'H', 'e', 'l', 'l', 'o', 'W', 'o', 'r', 'l', 'd',
+	}
+	if got := req.encode(); !bytes.Equal(got, want) {
+		t.Fatalf("set frame = % x, want % x", got, want)
+	}
+}
+
+func TestReadBinaryPacket(t *testing.T) {
+	frame := []byte{
+		0x81, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0xde, 0xad, 0xbe, 0xef,
+		'W', 'o', 'r', 'l', 'd',
+	}
+	tr := newScriptTransport(string(frame))
+	tr.Connect()
+	tr.Write("x")
+	tr.Flush()
+	resp, err := readBinaryPacket(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.opcode != opGet || resp.status != statusSuccess || resp.cas != 1 ||
+		!bytes.Equal(resp.extras, frame[24:28]) || len(resp.key) != 0 || string(resp.value) != "World" {
+		t.Fatalf("decoded %+v", resp)
+	}
+
+	bad := newScriptTransport(string(append([]byte{0x80}, frame[1:]...)))
+	bad.Connect()
+	bad.Write("x")
+	bad.Flush()
+	if _, err := readBinaryPacket(bad); err == nil {
+		t.Fatal("readBinaryPacket accepted a request magic")
+	}
+}
+
+func TestReadBinaryGetKPacket(t *testing.T) {
+	frame := []byte{
+		0x81, 0x0c, 0x00, 0x03, 0x04, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02,
+		0x00, 0x00, 0x00, 0x00,
+		'f', 'o', 'o',
+		'b', 'a',
+	}
+	tr := newScriptTransport(string(frame))
+	tr.Connect()
+	tr.Write("x")
+	tr.Flush()
+	resp, err := readBinaryPacket(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.opcode != opGetK || string(resp.key) != "foo" || string(resp.value) != "ba" {
+		t.Fatalf("decoded %+v", resp)
+	}
+
+	hit := func(opcode byte, key string, value string) []byte {
+		return (&binaryPacket{magic: binaryResponseMagic, opcode: opcode, extras: make([]byte, 4), key: []byte(key), value: []byte(value)}).encode()
+	}
+	replies := append(hit(opGetKQ, "app:c", "3"), hit(opGetK, "app:a", "1")...)
+	b := &MemcachedBinary{m: NewMemcachedWithTransport(newScriptTransport(string(replies)), WithPrefix("app:"))}
+	values, err := b.GetMulti([]Key{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values["a"] != "1" || values["c"] != "3" {
+		t.Fatalf("GetMulti correlated by key = %q", values)
+	}
+}
+
+func TestBinaryStatusErrors(t *testing.T) {
+	tests := []struct {
+		status uint16
+		want   error
+	}{
+		{statusSuccess, nil},
+		{statusKeyNotFound, ErrNotFound},
+		{statusKeyExists, ErrCASConflict},
+		{statusNotStored, ErrNotStored},
+		{statusAuthFailed, ErrAuthFailed},
+		{0x0081, ErrServerError},
+	}
+	for _, tt := range tests {
+		if err := statusError(&binaryPacket{status: tt.status}); !errors.Is(err, tt.want) {
+			t.Errorf("status 0x%04x = %v, want %v", tt.status, err, tt.want)
+		}
+	}
+}
+
+func TestBinaryClient(t *testing.T) {
+	srv := newMemServer()
+	b, err := NewMemcachedBinary("tcp", serveTCP(t, srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	key := Key("key with spaces\r\n")
+	if err := b.Set(key, "v\x00\r\n", 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := b.Get(key); err != nil || value != "v\x00\r\n" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+	if value, err := b.Get("missing"); err != nil || value != "" {
+		t.Fatalf("Get of a miss = %q, %v", value, err)
+	}
+	values, err := b.GetMulti([]Key{key, "missing"})
+	if err != nil || len(values) != 1 || values[key] != "v\x00\r\n" {
+		t.Fatalf("GetMulti = %q, %v", values, err)
+	}
+	if err := b.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete(key); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Delete of a miss = %v, want ErrNotFound", err)
+	}
+	if _, err := b.Get(""); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("Get with an empty key = %v, want ErrInvalidArgument", err)
+	}
+}
+
+func TestBinaryClientOptions(t *testing.T) {
+	srv := newMemServer()
+	b, err := NewMemcachedBinary("tcp", serveTCP(t, srv),
+		WithPrefix("svc:"), WithCompression(64), WithMaxValueSize(4096), WithMaxKeyLength(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	large := strings.Repeat("compressible ", 100)
+	if err := b.Set("k", large, 0); err != nil {
+		t.Fatal(err)
+	}
+	it, found := srv.item("svc:k")
+	if !found || it.flags&FlagCompressed == 0 || len(it.value) >= len(large) {
+		t.Fatalf("stored %v under the prefix, flags %v", found, it)
+	}
+	if value, err := b.Get("k"); err != nil || value != large {
+		t.Fatalf("Get of a compressed value = %d bytes, %v", len(value), err)
+	}
+	if values, err := b.GetMulti([]Key{"k"}); err != nil || values["k"] != large {
+		t.Fatalf("GetMulti of a compressed value = %d keys, %v", len(values), err)
+	}
+
+	noise := make([]byte, 8192)
+	rand.New(rand.NewSource(1)).Read(noise)
+	if err := b.Set("k", string(noise), 0); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("Set over the value size = %v, want ErrValueTooLarge", err)
+	}
+	if err := b.Set("long-key", "v", 0); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("Set with a prefixed key over the key length = %v, want ErrInvalidArgument", err)
+	}
+}
@@ -0,0 +1,234 @@
+package memcached
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultReplicas = 160
+
+// ServerSelector routes a key to a node. The hash ring of a Cluster is the
+// default selector.
+type ServerSelector interface {
+	PickServer(key Key) (*Memcached, error)
+}
+
+// Cluster sends reads and writes for a key to the same node, so a Get sees
+// the preceding Set. Only a breaker with rehash routes a key elsewhere while
+// its node is unavailable; there are no read replicas. SetToPrimary and
+// GetFromPrimary keep a key on its owner even then.
+type Cluster struct {
+	ring     *HashRing
+	selector ServerSelector
+	breaker  *breaker
+}
+
+func NewCluster(nodes ...*Memcached) (*Cluster, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("empty cluster\n")
+	}
+	ring := NewHashRing(defaultReplicas)
+	for _, node := range nodes {
+		ring.AddNode(node)
+	}
+	return &Cluster{ring: ring}, nil
+}
+
+// NewClusterWithBreaker stops sending commands to a node after threshold
+// consecutive connection failures. After cooldown a single command probes
+// the node again. With rehash the keys of an unavailable node go to the
+// next node on the ring instead of failing.
+func NewClusterWithBreaker(threshold int, cooldown time.Duration, rehash bool, nodes ...*Memcached) (*Cluster, error) {
+	if threshold < 1 {
+		return nil, fmt.Errorf("invalid breaker threshold: %d\n", threshold)
+	}
+	c, err := NewCluster(nodes...)
+	if err != nil {
+		return nil, err
+	}
+	c.breaker = &breaker{threshold: threshold, cooldown: cooldown, rehash: rehash, nodes: make(map[*Memcached]*nodeState)}
+	return c, nil
+}
+
+// NewClusterWithSelector routes keys with selector, which must return one of
+// nodes. AddNode and RemoveNode do not change its routing.
+func NewClusterWithSelector(selector ServerSelector, nodes ...*Memcached) (*Cluster, error) {
+	c, err := NewCluster(nodes...)
+	if err != nil {
+		return nil, err
+	}
+	c.selector = selector
+	return c, nil
+}
+
+func (c *Cluster) AddNode(node *Memcached) {
+	c.ring.AddNode(node)
+}
+
+func (c *Cluster) AddWeightedNode(node *Memcached, weight int) {
+	c.ring.AddWeightedNode(node, weight)
+}
+
+func (c *Cluster) RemoveNode(node *Memcached) {
+	c.ring.RemoveNode(node)
+}
+
+func (c *Cluster) Set(key Key, value string, ttl TTL) error {
+	return c.do(key, func(node *Memcached) error {
+		return node.Set(key, value, ttl)
+	})
+}
+
+func (c *Cluster) Get(key Key) (string, error) {
+	var value string
+	err := c.do(key, func(node *Memcached) error {
+		var err error
+		value, err = node.Get(key)
+		return err
+	})
+	return value, err
+}
+
+// SetToPrimary stores key on the node that owns it even when a breaker
+// with rehash would route it to the next node, so that GetFromPrimary
+// reads it back.
+func (c *Cluster) SetToPrimary(key Key, value string, ttl TTL) error {
+	node, err := c.primary(key)
+	if err != nil {
+		return err
+	}
+	return c.doOn(node, func(node *Memcached) error {
+		return node.Set(key, value, ttl)
+	})
+}
+
+// GetFromPrimary reads key from the node that owns it even when a breaker
+// with rehash would route it to the next node.
+func (c *Cluster) GetFromPrimary(key Key) (string, error) {
+	node, err := c.primary(key)
+	if err != nil {
+		return "", err
+	}
+	var value string
+	err = c.doOn(node, func(node *Memcached) error {
+		var err error
+		value, err = node.Get(key)
+		return err
+	})
+	return value, err
+}
+
+func (c *Cluster) Delete(key Key) error {
+	return c.do(key, func(node *Memcached) error {
+		return node.Delete(key)
+	})
+}
+
+func (c *Cluster) Close() {
+	for _, node := range c.ring.Nodes() {
+		node.Close()
+	}
+}
+
+func (c *Cluster) do(key Key, fn func(node *Memcached) error) error {
+	node, err := c.pick(key)
+	if err != nil {
+		return err
+	}
+	return c.doOn(node, fn)
+}
+
+func (c *Cluster) doOn(node *Memcached, fn func(node *Memcached) error) error {
+	err := fn(node)
+	if c.breaker != nil {
+		c.breaker.record(node, err)
+	}
+	return err
+}
+
+func (c *Cluster) primary(key Key) (*Memcached, error) {
+	var node *Memcached
+	var err error
+	if c.selector != nil {
+		node, err = c.selector.PickServer(key)
+	} else {
+		node, err = c.ring.PickServer(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.breaker != nil && !c.breaker.allow(node) {
+		return nil, fmt.Errorf("node is unavailable for key: %q: %w", key, ErrUnreachable)
+	}
+	return node, nil
+}
+
+func (c *Cluster) pick(key Key) (*Memcached, error) {
+	if c.selector != nil || c.breaker == nil || !c.breaker.rehash {
+		return c.primary(key)
+	}
+
+	nodes := c.ring.Successors(key)
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no nodes available for key: %q\n", key)
+	}
+	for _, node := range nodes {
+		if c.breaker.allow(node) {
+			return node, nil
+		}
+	}
+	return nil, fmt.Errorf("node is unavailable for key: %q: %w", key, ErrUnreachable)
+}
+
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	rehash    bool
+	mu        sync.Mutex
+	nodes     map[*Memcached]*nodeState
+}
+
+type nodeState struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *breaker) allow(node *Memcached) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.nodes[node]
+	if state == nil || state.failures < b.threshold {
+		return true
+	}
+	if state.probing || time.Now().Before(state.openUntil) {
+		return false
+	}
+	state.probing = true
+	return true
+}
+
+func (b *breaker) record(node *Memcached, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.nodes[node]
+	if !isNodeFailure(err) {
+		delete(b.nodes, node)
+		return
+	}
+	if state == nil {
+		state = &nodeState{}
+		b.nodes[node] = state
+	}
+	state.failures++
+	state.probing = false
+	if state.failures >= b.threshold {
+		state.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+func isNodeFailure(err error) bool {
+	return errors.Is(err, ErrUnreachable) || errors.Is(err, ErrTimeout) || isConnError(err)
+}
@@ -0,0 +1,270 @@
+package memcached
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTCPNodes starts n in-memory servers and returns a client for each.
+func newTCPNodes(t *testing.T, n int) ([]*Memcached, []*memServer) {
+	t.Helper()
+	var nodes []*Memcached
+	var servers []*memServer
+	for i := 0; i < n; i++ {
+		m, srv := newTCPClient(t)
+		nodes = append(nodes, m)
+		servers = append(servers, srv)
+	}
+	return nodes, servers
+}
+
+func sampleKeys(n int) []Key {
+	keys := make([]Key, n)
+	for i := range keys {
+		keys[i] = Key(fmt.Sprintf("key:%d", i))
+	}
+	return keys
+}
+
+func TestClusterRoutesDeterministically(t *testing.T) {
+	nodes, servers := newTCPNodes(t, 3)
+	c, err := NewCluster(nodes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := NewCluster(nodes...)
+
+	counts := make(map[*Memcached]int)
+	keys := sampleKeys(3000)
+	for _, key := range keys {
+		node := c.ring.Locate(key)
+		if again.ring.Locate(key) != node || c.ring.Locate(key) != node {
+			t.Fatalf("key %q maps to different nodes", key)
+		}
+		counts[node]++
+	}
+	for i, node := range nodes {
+		if share := counts[node]; share < len(keys)/5 || share > len(keys)/2 {
+			t.Errorf("node %d owns %d of %d keys", i, share, len(keys))
+		}
+	}
+
+	for _, key := range keys[:30] {
+		if err := c.Set(key, string(key), 0); err != nil {
+			t.Fatal(err)
+		}
+		owner := c.ring.Locate(key)
+		for i, srv := range servers {
+			if _, found := srv.item(string(key)); found != (nodes[i] == owner) {
+				t.Fatalf("key %q on node %d: %v", key, i, found)
+			}
+		}
+		if value, err := c.Get(key); err != nil || value != string(key) {
+			t.Fatalf("Get(%q) = %q, %v", key, value, err)
+		}
+	}
+	if err := c.Delete(keys[0]); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClusterNodeDown(t *testing.T) {
+	up, _ := newTCPClient(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down, _ := NewMemcached("tcp", ln.Addr().String())
+	ln.Close()
+	c, _ := NewCluster(up, down)
+
+	var upErrs, downErrs int
+	for _, key := range sampleKeys(50) {
+		err := c.Set(key, "v", 0)
+		switch c.ring.Locate(key) {
+		case up:
+			if err != nil {
+				upErrs++
+			}
+		case down:
+			if errors.Is(err, ErrUnreachable) {
+				downErrs++
+			}
+		}
+	}
+	if upErrs != 0 || downErrs == 0 {
+		t.Fatalf("%d errors on the live node, %d unreachable errors on the dead node", upErrs, downErrs)
+	}
+}
+
+func TestClusterBreaker(t *testing.T) {
+	up, upSrv, _ := newTestClient(t)
+	down, downSrv, downTr := newTestClient(t)
+	const cooldown = 50 * time.Millisecond
+	c, err := NewClusterWithBreaker(2, cooldown, true, up, down)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key Key
+	for _, k := range sampleKeys(100) {
+		if c.ring.Locate(k) == down {
+			key = k
+			break
+		}
+	}
+
+	dialErr := errors.New("connection refused")
+	downTr.connectErrs = []error{dialErr, dialErr}
+	for i := 0; i < 2; i++ {
+		if err := c.Set(key, "v", 0); !errors.Is(err, ErrUnreachable) {
+			t.Fatalf("Set %d on the failing node = %v", i, err)
+		}
+	}
+	if err := c.Set(key, "rehashed", 0); err != nil {
+		t.Fatalf("Set with the breaker open = %v", err)
+	}
+	if it, found := upSrv.item(string(key)); !found || string(it.value) != "rehashed" {
+		t.Fatal("the key was not rehashed to the next node")
+	}
+	if downTr.connects != 0 || len(downTr.connectErrs) != 0 {
+		t.Fatal("the open breaker still sent a command to the node")
+	}
+
+	time.Sleep(cooldown + 10*time.Millisecond)
+	downTr.connectErrs = []error{dialErr}
+	if err := c.Set(key, "v", 0); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("failed probe = %v", err)
+	}
+	if err := c.Set(key, "v", 0); err != nil || downTr.connects != 0 {
+		t.Fatalf("Set after a failed probe = %v, %d dials of the node", err, downTr.connects)
+	}
+
+	time.Sleep(cooldown + 10*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if err := c.Set(key, "recovered", 0); err != nil {
+			t.Fatalf("Set after the cooldown = %v", err)
+		}
+	}
+	if it, found := downSrv.item(string(key)); !found || string(it.value) != "recovered" {
+		t.Fatal("the node did not recover after the cooldown")
+	}
+}
+
+func TestClusterBreakerWithoutRehash(t *testing.T) {
+	down, _, downTr := newTestClient(t)
+	c, _ := NewClusterWithBreaker(1, time.Hour, false, down)
+	downTr.connectErrs = []error{errors.New("connection refused")}
+	for i := 0; i < 3; i++ {
+		if err := c.Set("k", "v", 0); !errors.Is(err, ErrUnreachable) {
+			t.Fatalf("Set %d = %v", i, err)
+		}
+	}
+	if downTr.connects != 0 || len(downTr.connectErrs) != 0 {
+		t.Fatal("the open breaker dialed the node")
+	}
+	if _, err := NewClusterWithBreaker(0, time.Second, false, down); err == nil {
+		t.Fatal("NewClusterWithBreaker accepted a zero threshold")
+	}
+}
+
+type prefixSelector struct {
+	users *Memcached
+	rest  *Memcached
+}
+
+func (s prefixSelector) PickServer(key Key) (*Memcached, error) {
+	if strings.HasPrefix(string(key), "user:") {
+		return s.users, nil
+	}
+	return s.rest, nil
+}
+
+func TestClusterWithSelector(t *testing.T) {
+	nodes, servers := newTCPNodes(t, 2)
+	c, err := NewClusterWithSelector(prefixSelector{users: nodes[0], rest: nodes[1]}, nodes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := c.Set(Key(fmt.Sprintf("user:%d", i)), "u", 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Set(Key(fmt.Sprintf("order:%d", i)), "o", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		if _, found := servers[0].item(fmt.Sprintf("user:%d", i)); !found {
+			t.Fatalf("user:%d is not on the users node", i)
+		}
+		if _, found := servers[1].item(fmt.Sprintf("user:%d", i)); found {
+			t.Fatalf("user:%d is on the other node", i)
+		}
+		if _, found := servers[1].item(fmt.Sprintf("order:%d", i)); !found {
+			t.Fatalf("order:%d is not on the other node", i)
+		}
+	}
+	if value, err := c.Get("user:7"); err != nil || value != "u" {
+		t.Fatalf("Get through the selector = %q, %v", value, err)
+	}
+}
+
+func TestClusterReadYourWrites(t *testing.T) {
+	nodes, servers := newTCPNodes(t, 3)
+	c, err := NewClusterWithBreaker(1, time.Hour, true, nodes...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range sampleKeys(50) {
+		writeNode, _ := c.pick(key)
+		readNode, _ := c.pick(key)
+		primary, _ := c.primary(key)
+		if writeNode != readNode || writeNode != primary {
+			t.Fatalf("key %q: writes go to %p, reads to %p, primary is %p", key, writeNode, readNode, primary)
+		}
+		if err := c.Set(key, "v", 0); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := c.GetFromPrimary(key); err != nil || value != "v" {
+			t.Fatalf("GetFromPrimary after Set = %q, %v", value, err)
+		}
+	}
+
+	var key Key
+	for _, k := range sampleKeys(100) {
+		if c.ring.Locate(k) == nodes[0] {
+			key = k
+			break
+		}
+	}
+	if err := c.SetToPrimary(key, "pinned", 0); err != nil {
+		t.Fatal(err)
+	}
+	if it, found := servers[0].item(string(key)); !found || string(it.value) != "pinned" {
+		t.Fatal("SetToPrimary did not store on the owner")
+	}
+
+	c.breaker.record(nodes[0], ErrUnreachable)
+	if err := c.Set(key, "rehashed", 0); err != nil {
+		t.Fatalf("Set with the owner unavailable = %v, want it rehashed", err)
+	}
+	if err := c.SetToPrimary(key, "v", 0); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("SetToPrimary with the owner unavailable = %v, want ErrUnreachable", err)
+	}
+	if _, err := c.GetFromPrimary(key); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("GetFromPrimary with the owner unavailable = %v, want ErrUnreachable", err)
+	}
+	if it, _ := servers[0].item(string(key)); string(it.value) != "pinned" {
+		t.Fatal("the owner was written while unavailable")
+	}
+}
+
+func TestNewClusterEmpty(t *testing.T) {
+	if _, err := NewCluster(); err == nil {
+		t.Fatal("NewCluster accepted no nodes")
+	}
+}
@@ -0,0 +1,93 @@
+package memcached
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+const FlagGob uint32 = 1 << 2
+
+type codec struct {
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+}
+
+// codecs maps the flag bit of a serialization to its functions. The
+// compression bit is handled on every store and retrieve.
+var codecs = map[uint32]codec{
+	FlagJSON: {marshal: json.Marshal, unmarshal: json.Unmarshal},
+	FlagGob:  {marshal: gobMarshal, unmarshal: gobUnmarshal},
+}
+
+// SetTyped serializes v with the codec selected in flags, which must hold
+// exactly one codec bit and may add FlagCompressed.
+func (m *Memcached) SetTyped(key Key, v interface{}, flags uint32, ttl TTL) error {
+	c, err := flagCodec(flags &^ FlagCompressed)
+	if err != nil {
+		return err
+	}
+	data, err := c.marshal(v)
+	if err != nil {
+		return fmt.Errorf("cannot marshal value: %q\n", err)
+	}
+	value := string(data)
+	if flags&FlagCompressed != 0 {
+		value, err = compress(value)
+		if err != nil {
+			return err
+		}
+	}
+	return m.setWithFlags(key, value, flags, ttl)
+}
+
+// GetTyped decodes the value into dest with the codec named by its flags
+// and returns ErrNotFound on a miss.
+func (m *Memcached) GetTyped(key Key, dest interface{}) error {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return validKeyErr
+	}
+
+	cmd := fmt.Sprintf("get %s", key)
+	items, err := m.retrieve(context.Background(), cmd)
+	err = m.bestEffort(err)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return ErrNotFound
+	}
+	c, err := flagCodec(items[0].flags)
+	if err != nil {
+		return err
+	}
+	err = c.unmarshal([]byte(items[0].value), dest)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal value: %q\n", err)
+	}
+	return nil
+}
+
+func flagCodec(flags uint32) (codec, error) {
+	c, ok := codecs[flags]
+	if !ok {
+		return codec{}, fmt.Errorf("unknown codec flags: %d\n", flags)
+	}
+	return c, nil
+}
+
+func gobMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gobUnmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
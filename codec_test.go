@@ -0,0 +1,54 @@
+package memcached
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type typedValue struct {
+	Name  string
+	Count int
+}
+
+func TestSetTypedCompressedJSON(t *testing.T) {
+	m, srv, _ := newTestClient(t)
+	in := typedValue{Name: "widget", Count: 3}
+	if err := m.SetTyped("k", in, FlagJSON|FlagCompressed, 0); err != nil {
+		t.Fatal(err)
+	}
+	it, _ := srv.item("k")
+	if it.flags != FlagJSON|FlagCompressed || string(it.value) == `{"Name":"widget","Count":3}` {
+		t.Fatalf("stored flags %d, value %q, want compressed json", it.flags, it.value)
+	}
+	var out typedValue
+	if err := m.GetTyped("k", &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("GetTyped = %+v, want %+v", out, in)
+	}
+
+	if err := m.SetTyped("gob", in, FlagGob, 0); err != nil {
+		t.Fatal(err)
+	}
+	out = typedValue{}
+	if err := m.GetTyped("gob", &out); err != nil || !reflect.DeepEqual(out, in) {
+		t.Fatalf("GetTyped of gob = %+v, %v", out, err)
+	}
+}
+
+func TestGetTypedUnknownFlags(t *testing.T) {
+	m, srv, _ := newTestClient(t)
+	srv.put("k", `{"Name":"x"}`, FlagJSON|1<<9)
+	var out typedValue
+	if err := m.GetTyped("k", &out); err == nil {
+		t.Fatal("GetTyped accepted unknown flag bits")
+	}
+	if err := m.SetTyped("k", out, FlagJSON|FlagGob, 0); err == nil {
+		t.Fatal("SetTyped accepted two codecs")
+	}
+	if err := m.GetTyped("missing", &out); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetTyped of a miss = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,53 @@
+package memcached
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+const FlagCompressed uint32 = 1 << 0
+
+func (m *Memcached) encodeValue(value string, flags uint32) (string, uint32, error) {
+	if flags&FlagCompressed != 0 || m.compressThreshold <= 0 || len(value) <= m.compressThreshold {
+		return value, flags, nil
+	}
+	compressed, err := compress(value)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(compressed) >= len(value) {
+		return value, flags, nil
+	}
+	return compressed, flags | FlagCompressed, nil
+}
+
+func compress(value string) (string, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := io.WriteString(w, value)
+	if err != nil {
+		return "", fmt.Errorf("cannot compress value: %q\n", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return "", fmt.Errorf("cannot compress value: %q\n", err)
+	}
+	return buf.String(), nil
+}
+
+func decodeValue(value string, flags uint32) (string, uint32, error) {
+	if flags&FlagCompressed == 0 {
+		return value, flags, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader([]byte(value)))
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot decompress value: %q\n", err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot decompress value: %q\n", err)
+	}
+	return string(data), flags &^ FlagCompressed, nil
+}
@@ -0,0 +1,40 @@
+package memcached
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	m, srv, _ := newTestClient(t, WithCompression(1024))
+	large := strings.Repeat(`{"id":1,"name":"item"},`, 1000)
+	if err := m.Set("large", large, 0); err != nil {
+		t.Fatal(err)
+	}
+	it, _ := srv.item("large")
+	if it.flags&FlagCompressed == 0 || len(it.value) >= len(large)/10 {
+		t.Fatalf("stored %d bytes with flags %d for a %d byte value", len(it.value), it.flags, len(large))
+	}
+	value, flags, err := m.GetWithFlags("large")
+	if err != nil || value != large || flags != 0 {
+		t.Fatalf("GetWithFlags = %d bytes, flags %d, %v", len(value), flags, err)
+	}
+
+	small := strings.Repeat("a", 1024)
+	if err := m.Set("small", small, 0); err != nil {
+		t.Fatal(err)
+	}
+	if it, _ := srv.item("small"); it.flags != 0 || string(it.value) != small {
+		t.Fatalf("value under the threshold stored with flags %d", it.flags)
+	}
+}
+
+func TestCompressionKeepsIncompressibleValues(t *testing.T) {
+	value, flags, err := (&Memcached{compressThreshold: 4}).encodeValue("abcdefgh", 0)
+	if err != nil || value != "abcdefgh" || flags != 0 {
+		t.Fatalf("encodeValue = %q, %d, %v, want the value unchanged", value, flags, err)
+	}
+	if _, _, err := decodeValue("not gzip", FlagCompressed); err == nil {
+		t.Fatal("decodeValue accepted a corrupt value")
+	}
+}
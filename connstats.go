@@ -0,0 +1,88 @@
+package memcached
+
+import (
+	"sync/atomic"
+)
+
+// ConnStats counts activity of a client since it was created. Errors leaves
+// out replies such as a miss or a failed conditional store.
+type ConnStats struct {
+	Commands     uint64
+	Reconnects   uint64
+	Errors       uint64
+	BytesRead    uint64
+	BytesWritten uint64
+}
+
+type connCounters struct {
+	replaced     atomic.Int64
+	commands     atomic.Uint64
+	reconnects   atomic.Uint64
+	errors       atomic.Uint64
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+}
+
+type countedTransport interface {
+	setCounters(c *connCounters)
+}
+
+func (m *Memcached) ConnStats() ConnStats {
+	return ConnStats{
+		Commands:     m.counters.commands.Load(),
+		Reconnects:   m.counters.reconnects.Load(),
+		Errors:       m.counters.errors.Load(),
+		BytesRead:    m.counters.bytesRead.Load(),
+		BytesWritten: m.counters.bytesWritten.Load(),
+	}
+}
+
+func (m *Memcached) countedFactory(factory func() Transport) func() Transport {
+	return func() Transport {
+		t := factory()
+		if ct, ok := t.(countedTransport); ok {
+			ct.setCounters(&m.counters)
+		}
+		return t
+	}
+}
+
+// dialed counts a reconnect when a transport dials again, or when a new
+// transport takes the place of one the pool dropped as broken. A pool that
+// grows under load dials without reconnecting.
+func (c *connCounters) dialed(before bool) {
+	if c == nil {
+		return
+	}
+	if c.takeReplaced() || before {
+		c.reconnects.Add(1)
+	}
+}
+
+func (c *connCounters) dropped() {
+	c.replaced.Add(1)
+}
+
+func (c *connCounters) takeReplaced() bool {
+	for {
+		n := c.replaced.Load()
+		if n <= 0 {
+			return false
+		}
+		if c.replaced.CompareAndSwap(n, n-1) {
+			return true
+		}
+	}
+}
+
+func (c *connCounters) read(n int) {
+	if c != nil {
+		c.bytesRead.Add(uint64(n))
+	}
+}
+
+func (c *connCounters) written(n int) {
+	if c != nil {
+		c.bytesWritten.Add(uint64(n))
+	}
+}
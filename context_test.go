@@ -0,0 +1,118 @@
+package memcached
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestContextDeadlineExceeded(t *testing.T) {
+	m, srv := newTCPClient(t)
+	srv.delay = 300 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := m.GetContext(ctx, "k"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetContext past its deadline = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("GetContext returned after %v", elapsed)
+	}
+
+	srv.mu.Lock()
+	srv.delay = 0
+	srv.mu.Unlock()
+	if err := m.SetContext(context.Background(), "k", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.conns.Load(); got != 2 {
+		t.Fatalf("connections = %d, want the aborted one closed and redialed", got)
+	}
+}
+
+func TestContextCanceledMidOperation(t *testing.T) {
+	m, srv := newTCPClient(t)
+	srv.delay = 300 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(30*time.Millisecond, cancel)
+
+	start := time.Now()
+	if err := m.SetContext(ctx, "k", "v", 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SetContext canceled mid-operation = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("SetContext returned after %v", elapsed)
+	}
+	if err := m.DeleteContext(ctx, "k"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("DeleteContext with a canceled context = %v", err)
+	}
+}
+
+func TestContextSuccess(t *testing.T) {
+	m, _, tr := newTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := m.SetContext(ctx, "k", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := m.GetContext(ctx, "k"); err != nil || value != "v" {
+		t.Fatalf("GetContext = %q, %v", value, err)
+	}
+	if err := m.DeleteContext(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if !tr.deadline.IsZero() {
+		t.Fatalf("deadline %v left on the transport", tr.deadline)
+	}
+}
+
+func TestGetMultiContextPartialResult(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 1024)
+				if _, err := conn.Read(buf); err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte("VALUE a 0 1\r\n1\r\nVALUE b 0 1\r\n2\r\n"))
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	m, _ := NewMemcached("tcp", ln.Addr().String())
+	defer m.Close()
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		values, err := m.GetMultiContext(ctx, []Key{"a", "b", "c"})
+		cancel()
+		if !errors.Is(err, ErrPartialResult) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("GetMultiContext past its deadline = %v, want ErrPartialResult", err)
+		}
+		want := map[Key]string{"a": "1", "b": "2"}
+		if !reflect.DeepEqual(values, want) {
+			t.Fatalf("partial values = %v, want %v", values, want)
+		}
+	}
+	if got := accepted.Load(); got != 2 {
+		t.Fatalf("connections = %d, want the mid-response one closed", got)
+	}
+}
@@ -0,0 +1,68 @@
+package memcached
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// dialRace connects to all addresses of a tcp host at once and keeps the
+// first connection that succeeds, so that a dead IPv6 or IPv4 path does not
+// hold up the other one.
+func (t *TransportSocket) dialRace(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{}
+	host, port, splitErr := net.SplitHostPort(t.address)
+	if !strings.HasPrefix(t.network, "tcp") || splitErr != nil || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, t.network, t.address)
+	}
+	lookupHost := t.lookupHost
+	if lookupHost == nil {
+		lookupHost = net.DefaultResolver.LookupHost
+	}
+	addrs, err := lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 1 {
+		return dialer.DialContext(ctx, t.network, net.JoinHostPort(addrs[0], port))
+	}
+
+	raceCtx, cancel := context.WithCancel(ctx)
+	results := make(chan dialResult, len(addrs))
+	for _, addr := range addrs {
+		go func(addr string) {
+			conn, err := dialer.DialContext(raceCtx, t.network, net.JoinHostPort(addr, port))
+			results <- dialResult{conn: conn, err: err}
+		}(addr)
+	}
+
+	var firstErr error
+	for i := range addrs {
+		r := <-results
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			continue
+		}
+		cancel()
+		go closeDialResults(results, len(addrs)-i-1)
+		return r.conn, nil
+	}
+	cancel()
+	return nil, firstErr
+}
+
+func closeDialResults(results chan dialResult, n int) {
+	for i := 0; i < n; i++ {
+		r := <-results
+		if r.conn != nil {
+			r.conn.Close()
+		}
+	}
+}
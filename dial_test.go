@@ -0,0 +1,93 @@
+package memcached
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialRaceUsesFirstReachableAddress(t *testing.T) {
+	srv := newMemServer()
+	_, port, _ := net.SplitHostPort(serveTCP(t, srv))
+	tr := NewTransportSocket("tcp", net.JoinHostPort("cache.test", port))
+	tr.setDialTimeout(time.Second)
+	var looked []string
+	tr.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		looked = append(looked, host)
+		return []string{"127.0.0.2", "127.0.0.1"}, nil
+	}
+	m := NewMemcachedWithTransport(tr)
+	defer m.Close()
+	if err := m.Set("k", "v", 0); err != nil {
+		t.Fatalf("Set with one dead address = %v", err)
+	}
+	if len(looked) != 1 || looked[0] != "cache.test" {
+		t.Fatalf("resolved %q", looked)
+	}
+	if got := srv.conns.Load(); got != 1 {
+		t.Fatalf("connections = %d, want 1", got)
+	}
+}
+
+func TestDialRaceAllAddressesFail(t *testing.T) {
+	tr := NewTransportSocket("tcp", "cache.test:1")
+	tr.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"127.0.0.2", "127.0.0.3"}, nil
+	}
+	if err := tr.Connect(); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Connect with no reachable address = %v, want ErrUnreachable", err)
+	}
+
+	tr.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	if err := tr.Connect(); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Connect with a failing resolver = %v, want ErrUnreachable", err)
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	m, srv := newTCPClient(t, WithDialTimeout(time.Second), WithCommandTimeout(50*time.Millisecond))
+	if err := m.Set("k", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	srv.mu.Lock()
+	srv.delay = 300 * time.Millisecond
+	srv.mu.Unlock()
+
+	start := time.Now()
+	if _, err := m.Get("k"); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Get from a slow server = %v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Fatalf("Get returned after %v", elapsed)
+	}
+
+	srv.mu.Lock()
+	srv.delay = 0
+	srv.mu.Unlock()
+	if value, err := m.Get("k"); err != nil || value != "v" {
+		t.Fatalf("Get after a timeout = %q, %v", value, err)
+	}
+	if got := srv.conns.Load(); got != 2 {
+		t.Fatalf("connections = %d, want the timed out one closed and redialed", got)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	tr := NewTransportSocket("tcp", "cache.test:11211")
+	tr.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	m := NewMemcachedWithTransport(tr, WithDialTimeout(50*time.Millisecond), WithCommandTimeout(time.Minute))
+	start := time.Now()
+	if err := m.Set("k", "v", 0); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Set with a hanging dial = %v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Set returned after %v, want the dial timeout", elapsed)
+	}
+}
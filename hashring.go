@@ -0,0 +1,121 @@
+package memcached
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type HashRing struct {
+	mu       sync.RWMutex
+	replicas int
+	hashes   []uint32
+	owners   map[uint32]*Memcached
+}
+
+func NewHashRing(replicas int) *HashRing {
+	if replicas < 1 {
+		replicas = 1
+	}
+	return &HashRing{replicas: replicas, owners: make(map[uint32]*Memcached)}
+}
+
+func (r *HashRing) AddNode(node *Memcached) {
+	r.AddWeightedNode(node, 1)
+}
+
+// AddWeightedNode places weight times the ring's replicas for node, so it
+// owns a proportionally larger share of the keyspace.
+func (r *HashRing) AddWeightedNode(node *Memcached, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := 0; i < r.replicas*weight; i++ {
+		hash := ringHash(node.name + "-" + strconv.Itoa(i))
+		if _, ok := r.owners[hash]; !ok {
+			r.hashes = append(r.hashes, hash)
+		}
+		r.owners[hash] = node
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+func (r *HashRing) RemoveNode(node *Memcached) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	hashes := r.hashes[:0]
+	for _, hash := range r.hashes {
+		if r.owners[hash] == node {
+			delete(r.owners, hash)
+			continue
+		}
+		hashes = append(hashes, hash)
+	}
+	r.hashes = hashes
+}
+
+func (r *HashRing) Locate(key Key) *Memcached {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.hashes) == 0 {
+		return nil
+	}
+	hash := ringHash(string(key))
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// Successors returns the distinct nodes in ring order starting at the owner
+// of key.
+func (r *HashRing) Successors(key Key) []*Memcached {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	hash := ringHash(string(key))
+	start := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	seen := make(map[*Memcached]bool)
+	var nodes []*Memcached
+	for j := 0; j < len(r.hashes); j++ {
+		node := r.owners[r.hashes[(start+j)%len(r.hashes)]]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+func (r *HashRing) PickServer(key Key) (*Memcached, error) {
+	node := r.Locate(key)
+	if node == nil {
+		return nil, fmt.Errorf("no nodes available for key: %q\n", key)
+	}
+	return node, nil
+}
+
+func (r *HashRing) Nodes() []*Memcached {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	seen := make(map[*Memcached]bool)
+	var nodes []*Memcached
+	for _, hash := range r.hashes {
+		node := r.owners[hash]
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+func ringHash(s string) uint32 {
+	sum := md5.Sum([]byte(s))
+	return binary.LittleEndian.Uint32(sum[:4])
+}
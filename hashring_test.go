@@ -0,0 +1,101 @@
+package memcached
+
+import (
+	"testing"
+)
+
+func newRingNodes(n int) []*Memcached {
+	nodes := make([]*Memcached, n)
+	for i := range nodes {
+		nodes[i] = NewMemcachedWithTransport(newScriptTransport())
+	}
+	return nodes
+}
+
+func TestHashRingRemoveNodeMovesItsShare(t *testing.T) {
+	nodes := newRingNodes(4)
+	ring := NewHashRing(defaultReplicas)
+	for _, node := range nodes {
+		ring.AddNode(node)
+	}
+	keys := sampleKeys(20000)
+	before := make(map[Key]*Memcached, len(keys))
+	for _, key := range keys {
+		before[key] = ring.Locate(key)
+	}
+
+	removed := nodes[1]
+	ring.RemoveNode(removed)
+	moved := 0
+	for _, key := range keys {
+		owner := ring.Locate(key)
+		if owner == removed {
+			t.Fatalf("key %q still maps to the removed node", key)
+		}
+		if owner != before[key] {
+			if before[key] != removed {
+				t.Fatalf("key %q moved between surviving nodes", key)
+			}
+			moved++
+		}
+	}
+	if share := float64(moved) / float64(len(keys)); share < 0.15 || share > 0.35 {
+		t.Fatalf("removing one of four nodes moved %.2f of the keys, want about a quarter", share)
+	}
+}
+
+func TestHashRingTransportNodesAreDistinct(t *testing.T) {
+	nodes := newRingNodes(2)
+	ring := NewHashRing(defaultReplicas)
+	ring.AddNode(nodes[0])
+	ring.AddNode(nodes[1])
+	if got := len(ring.Nodes()); got != 2 {
+		t.Fatalf("ring has %d nodes, want 2", got)
+	}
+
+	counts := make(map[*Memcached]int)
+	for _, key := range sampleKeys(1000) {
+		counts[ring.Locate(key)]++
+	}
+	if counts[nodes[0]] < 300 || counts[nodes[1]] < 300 {
+		t.Fatalf("keys split %d/%d between two nodes", counts[nodes[0]], counts[nodes[1]])
+	}
+
+	ring.RemoveNode(nodes[1])
+	for _, key := range sampleKeys(100) {
+		if ring.Locate(key) != nodes[0] {
+			t.Fatalf("key %q is not on the remaining node", key)
+		}
+	}
+}
+
+func TestHashRingWeightedDistribution(t *testing.T) {
+	nodes := newRingNodes(3)
+	weights := []int{1, 2, 3}
+	ring := NewHashRing(defaultReplicas)
+	for i, node := range nodes {
+		ring.AddWeightedNode(node, weights[i])
+	}
+	keys := sampleKeys(60000)
+	counts := make(map[*Memcached]int)
+	for _, key := range keys {
+		counts[ring.Locate(key)]++
+	}
+	for i, node := range nodes {
+		want := float64(weights[i]) / 6
+		share := float64(counts[node]) / float64(len(keys))
+		if share < want*0.8 || share > want*1.2 {
+			t.Errorf("node of weight %d owns %.3f of the keys, want about %.3f", weights[i], share, want)
+		}
+	}
+}
+
+func TestHashRingEmpty(t *testing.T) {
+	ring := NewHashRing(0)
+	if ring.Locate("k") != nil {
+		t.Fatal("empty ring located a node")
+	}
+	if _, err := ring.PickServer("k"); err == nil {
+		t.Fatal("empty ring picked a server")
+	}
+}
@@ -0,0 +1,22 @@
+package memcached
+
+import (
+	"strings"
+)
+
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+func WithLogger(logger Logger) Option {
+	return func(m *Memcached) {
+		m.logger = logger
+	}
+}
+
+func commandLine(cmd string) string {
+	if i := strings.Index(cmd, "\r\n"); i >= 0 {
+		return cmd[:i]
+	}
+	return cmd
+}
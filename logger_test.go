@@ -0,0 +1,30 @@
+package memcached
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoggerRedactsValues(t *testing.T) {
+	logs := &logBuffer{}
+	m, _, _ := newTestClient(t, WithLogger(logs))
+	if err := m.Set("k", "top-secret-value", 0); err != nil {
+		t.Fatal(err)
+	}
+	got := logs.String()
+	if !strings.Contains(got, "set k 0 0 16") || !strings.Contains(got, "STORED") {
+		t.Fatalf("log %q, want the set command and its reply", got)
+	}
+	if strings.Contains(got, "top-secret-value") {
+		t.Fatalf("log %q contains the value", got)
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	if got := commandLine("set k 0 0 1\r\nv"); got != "set k 0 0 1" {
+		t.Fatalf("commandLine = %q", got)
+	}
+	if got := commandLine("get k"); got != "get k" {
+		t.Fatalf("commandLine = %q", got)
+	}
+}
@@ -0,0 +1,94 @@
+package memcached
+
+import (
+	"sync"
+	"time"
+)
+
+// MapCache is an in-memory Cache for tests. It follows the ttl rules of
+// memcached and reads the time from now.
+type MapCache struct {
+	mu    sync.Mutex
+	now   func() time.Time
+	items map[Key]mapItem
+}
+
+type mapItem struct {
+	value   string
+	expires time.Time
+}
+
+func NewMapCache(now func() time.Time) *MapCache {
+	if now == nil {
+		now = time.Now
+	}
+	return &MapCache{now: now, items: make(map[Key]mapItem)}
+}
+
+func (c *MapCache) Set(key Key, value string, ttl TTL) error {
+	validKeyErr := key.Validate()
+	if validKeyErr != nil {
+		return validKeyErr
+	}
+	validTtlErr := ttl.isValid()
+	if validTtlErr != nil {
+		return validTtlErr
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key] = mapItem{value: value, expires: c.expires(ttl)}
+	return nil
+}
+
+func (c *MapCache) Get(key Key) (string, error) {
+	validKeyErr := key.Validate()
+	if validKeyErr != nil {
+		return "", validKeyErr
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	it, ok := c.lookup(key)
+	if !ok {
+		return "", nil
+	}
+	return it.value, nil
+}
+
+func (c *MapCache) Delete(key Key) error {
+	validKeyErr := key.Validate()
+	if validKeyErr != nil {
+		return validKeyErr
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.lookup(key); !ok {
+		return ErrNotFound
+	}
+	delete(c.items, key)
+	return nil
+}
+
+func (c *MapCache) lookup(key Key) (mapItem, bool) {
+	it, ok := c.items[key]
+	if !ok {
+		return it, false
+	}
+	if !it.expires.IsZero() && !c.now().Before(it.expires) {
+		delete(c.items, key)
+		return it, false
+	}
+	return it, true
+}
+
+func (c *MapCache) expires(ttl TTL) time.Time {
+	if ttl == 0 {
+		return time.Time{}
+	}
+	if ttl > maxRelativeTTL {
+		return time.Unix(int64(ttl), 0)
+	}
+	return c.now().Add(time.Duration(ttl) * time.Second)
+}
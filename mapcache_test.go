@@ -0,0 +1,58 @@
+package memcached
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var _ Cache = (*MapCache)(nil)
+
+func TestMapCacheExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := NewMapCache(func() time.Time { return now })
+	if err := c.Set("short", "v", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("forever", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Set("absolute", "v", TTL(now.Add(time.Hour).Unix())); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := c.Get("short"); value != "v" {
+		t.Fatalf("Get before expiry = %q", value)
+	}
+
+	now = now.Add(10 * time.Second)
+	if value, err := c.Get("short"); err != nil || value != "" {
+		t.Fatalf("Get after expiry = %q, %v, want a miss", value, err)
+	}
+	if value, _ := c.Get("absolute"); value != "v" {
+		t.Fatal("an absolute ttl expired early")
+	}
+	now = now.Add(time.Hour)
+	if value, _ := c.Get("absolute"); value != "" {
+		t.Fatal("an absolute ttl did not expire")
+	}
+	if value, _ := c.Get("forever"); value != "v" {
+		t.Fatal("a ttl of 0 expired")
+	}
+	if err := c.Set("bad key", "v", 0); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("Set of an invalid key = %v", err)
+	}
+}
+
+func TestMapCacheDelete(t *testing.T) {
+	c := NewMapCache(nil)
+	_ = c.Set("k", "v", 0)
+	if err := c.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := c.Get("k"); value != "" {
+		t.Fatalf("Get after Delete = %q", value)
+	}
+	if err := c.Delete("k"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Delete of a missing key = %v, want ErrNotFound", err)
+	}
+}
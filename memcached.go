@@ -2,218 +2,1729 @@ package memcached
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"net"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+var (
+	ErrNotStored       = errors.New("not stored\n")
+	ErrNotFound        = errors.New("not found\n")
+	ErrCASConflict     = errors.New("cas conflict\n")
+	ErrTimeout         = errors.New("timeout\n")
+	ErrUnreachable     = errors.New("server unreachable\n")
+	ErrServerError     = errors.New("server error\n")
+	ErrAuthFailed      = errors.New("authentication failed\n")
+	ErrValueTooLarge   = errors.New("value too large\n")
+	ErrInvalidArgument = errors.New("invalid argument\n")
+	ErrLikelyEvicted   = errors.New("likely evicted\n")
+	ErrPartialResult   = errors.New("partial result\n")
+	ErrBusy            = errors.New("server busy\n")
+	ErrBadClass        = errors.New("bad slab class\n")
+)
+
+// Transport carries the text protocol to one server. Connect is called
+// before every command and must do nothing when already connected. Write
+// may buffer until Flush. ReadLine returns a line with its "\r\n" and ReadN
+// returns exactly n bytes. A zero SetDeadline clears the deadline.
 type Transport interface {
-	connect() error
+	Connect() error
 	Close()
 	Write(string) error
-	Read([]byte) (string, error)
+	Flush() error
+	ReadLine() (string, error)
+	ReadN(n int) ([]byte, error)
+	SetDeadline(time.Time)
 }
 
 type Cache interface {
-	Set(key key, value string, ttl ttl) error
-	Get(key key) (string, error)
-	Delete(key key) error
+	Set(key Key, value string, ttl TTL) error
+	Get(key Key) (string, error)
+	Delete(key Key) error
 }
 
 type TransportSocket struct {
-	network string
-	address string
-	conn    net.Conn
-	reader  *bufio.Reader
+	network     string
+	address     string
+	dialTimeout time.Duration
+	ioTimeout   time.Duration
+	bufSize     int
+	tlsConfig   *tls.Config
+	username    string
+	password    string
+	mu          sync.Mutex
+	deadline    time.Time
+	conn        net.Conn
+	reader      *bufio.Reader
+	writer      *bufio.Writer
+	counters    *connCounters
+	dialed      bool
+	lookupHost  func(ctx context.Context, host string) ([]string, error)
 }
 
-func (t *TransportSocket) connect() error {
+func (t *TransportSocket) Connect() error {
 	if t.conn != nil {
 		return nil
 	}
-	var dialErr error
-	t.conn, dialErr = net.Dial(t.network, t.address)
+	conn, dialErr := t.dial()
 	if dialErr != nil {
-		return fmt.Errorf("cannot connect: %q\n", dialErr)
+		if isTimeout(dialErr) {
+			return ErrTimeout
+		}
+		return fmt.Errorf("cannot connect: %q: %w", dialErr, ErrUnreachable)
+	}
+	t.counters.dialed(t.dialed)
+	t.dialed = true
+	t.mu.Lock()
+	t.conn = conn
+	t.mu.Unlock()
+	if t.bufSize > 0 {
+		t.reader = bufio.NewReaderSize(t.conn, t.bufSize)
+	} else {
+		t.reader = bufio.NewReader(t.conn)
+	}
+	t.writer = bufio.NewWriter(t.conn)
+	if t.username != "" {
+		return t.authenticate()
+	}
+	return nil
+}
+
+func (t *TransportSocket) authenticate() error {
+	writeErr := t.Write(string(saslPlainRequest(t.username, t.password).encode()))
+	if writeErr == nil {
+		writeErr = t.Flush()
+	}
+	if writeErr != nil {
+		t.Close()
+		return fmt.Errorf("cannot authenticate: %q\n", writeErr)
+	}
+	resp, readErr := readBinaryPacket(t)
+	if readErr != nil {
+		t.Close()
+		return fmt.Errorf("cannot authenticate: %q\n", readErr)
+	}
+	if resp.status != statusSuccess {
+		t.Close()
+		return ErrAuthFailed
 	}
-	t.reader = bufio.NewReader(t.conn)
 	return nil
 }
 
+func (t *TransportSocket) dial() (net.Conn, error) {
+	ctx := context.Background()
+	if t.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.dialTimeout)
+		defer cancel()
+	}
+	conn, err := t.dialRace(ctx)
+	if err != nil || t.tlsConfig == nil {
+		return conn, err
+	}
+
+	cfg := t.tlsConfig
+	if cfg.ServerName == "" {
+		host, _, splitErr := net.SplitHostPort(t.address)
+		if splitErr == nil {
+			cfg = cfg.Clone()
+			cfg.ServerName = host
+		}
+	}
+	tlsConn := tls.Client(conn, cfg)
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
 func (t *TransportSocket) Close() {
 	if t.conn == nil {
 		return
 	}
 	err := t.conn.Close()
+	t.mu.Lock()
+	t.conn = nil
+	t.mu.Unlock()
 	if err != nil {
 		fmt.Println("cannot close connection: ", err)
 	}
 }
 
+// Write buffers data until Flush or until the buffer fills up.
 func (t *TransportSocket) Write(data string) error {
-	_, err := t.conn.Write([]byte(data))
-	return err
+	readyErr := t.writeReady()
+	if readyErr != nil {
+		return readyErr
+	}
+	n, err := t.writer.WriteString(data)
+	t.counters.written(n)
+	return t.ioError(err)
 }
 
-func (t *TransportSocket) Read(bytes []byte) (string, error) {
-	var line string
-	var err error
-	if len(bytes) == 0 {
-		line, err = t.reader.ReadString('\n')
-		if err != nil {
-			return "", err
+func (t *TransportSocket) Flush() error {
+	readyErr := t.writeReady()
+	if readyErr != nil {
+		return readyErr
+	}
+	return t.ioError(t.writer.Flush())
+}
+
+func (t *TransportSocket) writeReady() error {
+	if t.conn == nil {
+		return net.ErrClosed
+	}
+	if deadline := t.opDeadline(); !deadline.IsZero() {
+		deadlineErr := t.conn.SetWriteDeadline(deadline)
+		if deadlineErr != nil {
+			return deadlineErr
 		}
-		return line, nil
 	}
-	_, err = t.reader.Read(bytes)
+	return nil
+}
+
+func (t *TransportSocket) ReadLine() (string, error) {
+	readyErr := t.readReady()
+	if readyErr != nil {
+		return "", readyErr
+	}
+	line, err := t.reader.ReadString('\n')
+	t.counters.read(len(line))
 	if err != nil {
-		return "", err
+		return "", t.ioError(err)
+	}
+	return line, nil
+}
+
+func (t *TransportSocket) ReadN(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	err := t.readFull(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (t *TransportSocket) readFull(buf []byte) error {
+	readyErr := t.readReady()
+	if readyErr != nil {
+		return readyErr
+	}
+	read, err := io.ReadFull(t.reader, buf)
+	t.counters.read(read)
+	if err != nil {
+		return t.ioError(err)
+	}
+	return nil
+}
+
+func (t *TransportSocket) readReady() error {
+	if t.conn == nil {
+		return net.ErrClosed
+	}
+	if deadline := t.opDeadline(); !deadline.IsZero() {
+		deadlineErr := t.conn.SetReadDeadline(deadline)
+		if deadlineErr != nil {
+			return deadlineErr
+		}
+	}
+	return nil
+}
+
+func (t *TransportSocket) setCounters(c *connCounters) {
+	t.counters = c
+}
+
+func (t *TransportSocket) SetDeadline(deadline time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deadline = deadline
+	if t.conn != nil {
+		_ = t.conn.SetDeadline(deadline)
+	}
+}
+
+func (t *TransportSocket) opDeadline() time.Time {
+	t.mu.Lock()
+	deadline := t.deadline
+	t.mu.Unlock()
+	if t.ioTimeout > 0 {
+		ioDeadline := time.Now().Add(t.ioTimeout)
+		if deadline.IsZero() || ioDeadline.Before(deadline) {
+			deadline = ioDeadline
+		}
+	}
+	return deadline
+}
+
+func (t *TransportSocket) ioError(err error) error {
+	if err != nil && isTimeout(err) {
+		t.Close()
+		return ErrTimeout
 	}
-	return string(bytes), nil
+	return err
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func (t *TransportSocket) setDialTimeout(d time.Duration) {
+	t.dialTimeout = d
 }
 
 func NewTransportSocket(network string, address string) *TransportSocket {
 	return &TransportSocket{network: network, address: address}
 }
 
-type ttl int32
+func NewTransportSocketBuffered(network string, address string, bufSize int) *TransportSocket {
+	return &TransportSocket{network: network, address: address, bufSize: bufSize}
+}
+
+// NewTransportSocketSASL authenticates with a binary protocol request, so
+// it is meant for MemcachedBinary; see NewMemcachedBinarySASL.
+func NewTransportSocketSASL(network string, address string, username string, password string) *TransportSocket {
+	return &TransportSocket{network: network, address: address, username: username, password: password}
+}
+
+func NewTransportSocketWithTimeout(network string, address string, dial time.Duration, io time.Duration) *TransportSocket {
+	return &TransportSocket{network: network, address: address, dialTimeout: dial, ioTimeout: io}
+}
+
+// TTL is a relative number of seconds up to 30 days (2592000); memcached
+// treats anything larger as an absolute Unix timestamp. Zero means no expiration.
+type TTL int32
 
-func (t ttl) isValid() error {
+const maxRelativeTTL TTL = 60 * 60 * 24 * 30
+
+func (t TTL) Validate() error {
+	return t.isValid()
+}
+
+func (t TTL) isValid() error {
+	if t < 0 {
+		return fmt.Errorf("negative ttl: %d: %w", t, ErrInvalidArgument)
+	}
 	return nil
 }
 
-type key string
+func TTLFromDuration(d time.Duration) TTL {
+	return ttlFromDuration(d, time.Now())
+}
+
+func TTLFromTime(t time.Time) TTL {
+	return ttlFromTime(t, time.Now())
+}
+
+// ttlFromDuration encodes a negative duration as a timestamp that has
+// already passed, since a ttl of 0 would keep the item forever.
+func ttlFromDuration(d time.Duration, now time.Time) TTL {
+	if d == 0 {
+		return 0
+	}
+	if d < 0 {
+		return TTL(now.Unix() - 1)
+	}
+	seconds := (d + time.Second - 1) / time.Second
+	if seconds > time.Duration(maxRelativeTTL) {
+		return TTL(now.Add(d).Unix())
+	}
+	return TTL(seconds)
+}
+
+func ttlFromTime(t time.Time, now time.Time) TTL {
+	d := t.Sub(now)
+	if d > 0 && d <= time.Duration(maxRelativeTTL)*time.Second {
+		return ttlFromDuration(d, now)
+	}
+	return TTL(t.Unix())
+}
+
+type Key string
 
-func (k *key) isValid() error {
+// Validate applies the default rules; ValidateKey also accounts for the
+// prefix and the key length limit of a client.
+func (k Key) Validate() error {
+	return k.isValid(defaultMaxKeyLength)
+}
+
+func (m *Memcached) ValidateKey(k Key) error {
+	_, err := m.validKey(k)
+	return err
+}
+
+func (m *Memcached) validKey(k Key) (Key, error) {
+	k = Key(m.prefix) + k
+	return k, k.isValid(m.maxKeyLength)
+}
+
+func (k *Key) isValid(maxLength int) error {
 	if len(*k) == 0 {
-		return fmt.Errorf("empty key\n")
+		return fmt.Errorf("empty key: %w", ErrInvalidArgument)
 	}
-	if len(*k) > 250 {
-		return fmt.Errorf("key too long\n")
+	if len(*k) > maxLength {
+		return fmt.Errorf("key too long: %w", ErrInvalidArgument)
 	}
 	var re = regexp.MustCompile(`[\x00-\x1F\x7F\s]`)
 	if re.MatchString(string(*k)) {
-		return fmt.Errorf("invalid key\n")
+		return fmt.Errorf("invalid key: %q: %w", *k, ErrInvalidArgument)
 	}
 	return nil
 }
 
 type Memcached struct {
-	transport Transport
+	address           string
+	name              string
+	pool              *pool
+	compressThreshold int
+	prefix            string
+	logger            Logger
+	maxValueSize      int
+	maxKeyLength      int
+	maxGetLine        int
+	now               func() time.Time
+	counters          connCounters
+	bestEffortMode    bool
+	lenient           bool
+	bufferPool        *sync.Pool
+	dialTimeout       time.Duration
+	commandTimeout    time.Duration
+	defaultTTL        TTL
+	random            func(n int64) int64
+	idleCheck         time.Duration
+	observer          Observer
+	retries           int
+	retryBackoff      time.Duration
+	pendingMu         sync.Mutex
+	pending           map[Transport]bool
 }
 
-func NewMemcached(network string, address string) (*Memcached, error) {
-	return &Memcached{transport: NewTransportSocket(network, address)}, nil
+const (
+	defaultMaxValueSize = 1024 * 1024
+	defaultMaxKeyLength = 250
+	defaultMaxGetLine   = 8192
+)
+
+func NewMemcached(network string, address string, opts ...Option) (*Memcached, error) {
+	return NewMemcachedPool(network, address, 1, opts...)
 }
 
-func (m *Memcached) Set(key key, value string, ttl ttl) error {
-	validKeyErr := key.isValid()
-	if validKeyErr != nil {
-		return validKeyErr
+func NewMemcachedPool(network string, address string, maxConns int, opts ...Option) (*Memcached, error) {
+	if maxConns < 1 {
+		return nil, fmt.Errorf("invalid pool size: %d\n", maxConns)
 	}
-	validTtlErr := ttl.isValid()
+	factory := func() Transport {
+		return NewTransportSocket(network, address)
+	}
+	return newMemcached(address, maxConns, factory, opts), nil
+}
+
+func NewMemcachedUnix(socketPath string, opts ...Option) (*Memcached, error) {
+	return NewMemcached("unix", socketPath, opts...)
+}
+
+func NewMemcachedWithTimeout(network string, address string, dial time.Duration, io time.Duration, opts ...Option) (*Memcached, error) {
+	factory := func() Transport {
+		return NewTransportSocketWithTimeout(network, address, dial, io)
+	}
+	return newMemcached(address, 1, factory, opts), nil
+}
+
+func NewMemcachedBuffered(network string, address string, bufSize int, opts ...Option) (*Memcached, error) {
+	factory := func() Transport {
+		return NewTransportSocketBuffered(network, address, bufSize)
+	}
+	return newMemcached(address, 1, factory, opts), nil
+}
+
+func NewMemcachedWithPrefix(network string, address string, prefix string, opts ...Option) (*Memcached, error) {
+	return NewMemcached(network, address, append([]Option{WithPrefix(prefix)}, opts...)...)
+}
+
+// NewMemcachedWithTransport sends every command over t; a broken t is
+// closed and connected again on the next command.
+func NewMemcachedWithTransport(t Transport, opts ...Option) *Memcached {
+	factory := func() Transport {
+		return t
+	}
+	return newMemcached("", 1, factory, opts)
+}
+
+func NewMemcachedConnected(network string, address string, opts ...Option) (*Memcached, error) {
+	m, err := NewMemcached(network, address, opts...)
+	if err != nil {
+		return nil, err
+	}
+	err = m.connect()
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func newMemcached(address string, maxConns int, factory func() Transport, opts []Option) *Memcached {
+	m := &Memcached{address: address, name: nodeName(address), maxValueSize: defaultMaxValueSize, maxKeyLength: defaultMaxKeyLength, maxGetLine: defaultMaxGetLine, now: time.Now, random: rand.Int63n}
+	m.pool = newPool(maxConns, m.timeoutFactory(m.countedFactory(factory)))
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+var nodeSeq atomic.Uint64
+
+// nodeName identifies a client on a hash ring. A client built on a
+// transport has no address, so it gets a name of its own instead of
+// sharing the ring positions of every other such client.
+func nodeName(address string) string {
+	if address != "" {
+		return address
+	}
+	return fmt.Sprintf("transport-%d", nodeSeq.Add(1))
+}
+
+type dialTimeoutTransport interface {
+	setDialTimeout(d time.Duration)
+}
+
+func (m *Memcached) timeoutFactory(factory func() Transport) func() Transport {
+	return func() Transport {
+		t := factory()
+		if dt, ok := t.(dialTimeoutTransport); ok && m.dialTimeout > 0 {
+			dt.setDialTimeout(m.dialTimeout)
+		}
+		return t
+	}
+}
+
+// TTLFromDuration is like the package function but reads the client clock.
+func (m *Memcached) TTLFromDuration(d time.Duration) TTL {
+	return ttlFromDuration(d, m.now())
+}
+
+// TTLFromTime is like the package function but reads the client clock.
+func (m *Memcached) TTLFromTime(t time.Time) TTL {
+	return ttlFromTime(t, m.now())
+}
+
+func (m *Memcached) Set(key Key, value string, ttl TTL) error {
+	return m.SetContext(context.Background(), key, value, ttl)
+}
+
+func (m *Memcached) SetContext(ctx context.Context, key Key, value string, ttl TTL) error {
+	resp, err := m.store(ctx, "set", key, value, 0, ttl)
+	if err != nil {
+		return m.bestEffort(err)
+	}
+	if resp != "STORED\r\n" {
+		return fmt.Errorf("value is not stored: %q: %w", resp, ErrNotStored)
+	}
+	return err
+}
+
+// SetBytes is Set for binary values. It copies value once: the command is
+// built and written as a string, since Transport.Write takes one. Use
+// SetStream to send a large value without holding a second copy.
+func (m *Memcached) SetBytes(key Key, value []byte, ttl TTL) error {
+	return m.Set(key, string(value), ttl)
+}
+
+func (m *Memcached) SetDefault(key Key, value string) error {
+	return m.Set(key, value, m.defaultTTL)
+}
+
+// SetWithJitter adds up to jitter seconds to base. A relative base that
+// the jitter pushes past 30 days is turned into an absolute time. A base
+// of 0 never expires and gets no jitter.
+func (m *Memcached) SetWithJitter(key Key, value string, base TTL, jitter TTL) error {
+	validTtlErr := base.isValid()
+	if validTtlErr != nil {
+		return validTtlErr
+	}
+	validTtlErr = jitter.isValid()
 	if validTtlErr != nil {
 		return validTtlErr
 	}
+	ttl := base
+	if base > 0 && jitter > 0 {
+		ttl += TTL(m.random(int64(jitter) + 1))
+	}
+	if base <= maxRelativeTTL && ttl > maxRelativeTTL {
+		ttl = m.TTLFromDuration(time.Duration(ttl) * time.Second)
+	}
+	return m.Set(key, value, ttl)
+}
 
-	cmd := fmt.Sprintf("set %s 0 %d %d\r\n%s", key, ttl, len(value), value)
-	resp, err := m.command(cmd)
+// SetAndVerify reads the value back after storing it, which doubles the
+// round trips; a value that is already gone points to eviction pressure.
+func (m *Memcached) SetAndVerify(key Key, value string, ttl TTL) error {
+	err := m.Set(key, value, ttl)
 	if err != nil {
 		return err
 	}
+	stored, _, found, err := m.GetCas(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("value is missing after set: %q: %w", key, ErrLikelyEvicted)
+	}
+	if stored != value {
+		return fmt.Errorf("value is changed after set: %q\n", key)
+	}
+	return nil
+}
+
+// ReservedFlagMask covers the flag bits the library sets itself, user flags
+// must leave them clear.
+const ReservedFlagMask = FlagCompressed | FlagJSON | FlagGob
+
+func (m *Memcached) SetWithFlags(key Key, value string, flags uint32, ttl TTL) error {
+	if flags&ReservedFlagMask != 0 {
+		return fmt.Errorf("flags overlap reserved bits: %d: %w", flags, ErrInvalidArgument)
+	}
+	return m.setWithFlags(key, value, flags, ttl)
+}
+
+func (m *Memcached) setWithFlags(key Key, value string, flags uint32, ttl TTL) error {
+	resp, err := m.store(context.Background(), "set", key, value, flags, ttl)
+	if err != nil {
+		return m.bestEffort(err)
+	}
 	if resp != "STORED\r\n" {
-		return fmt.Errorf("value is not stored: %q\n", resp)
+		return fmt.Errorf("value is not stored: %q: %w", resp, ErrNotStored)
 	}
-	return err
+	return nil
 }
 
-func (m *Memcached) Get(key key) (string, error) {
-	validKeyErr := key.isValid()
-	if validKeyErr != nil {
-		return "", validKeyErr
+func (m *Memcached) SetWithDuration(key Key, value string, d time.Duration) error {
+	return m.Set(key, value, m.TTLFromDuration(d))
+}
+
+func (m *Memcached) Add(key Key, value string, ttl TTL) error {
+	resp, err := m.store(context.Background(), "add", key, value, 0, ttl)
+	if err != nil {
+		return m.bestEffort(err)
 	}
+	return storeReply(resp)
+}
 
-	eof := "END\r\n"
+// Upsert reports created when the add succeeds. Otherwise the value is
+// stored with set, which does not depend on the key still being present, so
+// a concurrent delete or insert between the two commands cannot fail it.
+func (m *Memcached) Upsert(key Key, value string, ttl TTL) (created bool, err error) {
+	err = m.Add(key, value, ttl)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, ErrNotStored) {
+		return false, err
+	}
+	return false, m.Set(key, value, ttl)
+}
 
-	cmd := fmt.Sprintf("get %s", key)
-	header, err := m.command(cmd)
+func (m *Memcached) Replace(key Key, value string, ttl TTL) error {
+	resp, err := m.store(context.Background(), "replace", key, value, 0, ttl)
 	if err != nil {
-		return "", err
+		return m.bestEffort(err)
 	}
-	if header == eof {
-		return "", nil
+	return storeReply(resp)
+}
+
+func (m *Memcached) Append(key Key, value string) error {
+	resp, err := m.store(context.Background(), "append", key, value, 0, 0)
+	if err != nil {
+		return m.bestEffort(err)
 	}
+	return storeReply(resp)
+}
 
-	flags, bytes := 0, 0
-	n, err := fmt.Sscanf(header, "VALUE %s %d %d\r\n", &key, &flags, &bytes)
-	if n != 3 {
-		return "", fmt.Errorf("cannot parse header: %q\n", header)
+func (m *Memcached) Prepend(key Key, value string) error {
+	resp, err := m.store(context.Background(), "prepend", key, value, 0, 0)
+	if err != nil {
+		return m.bestEffort(err)
 	}
-	dataBuf := make([]byte, bytes)
-	_, err = m.transport.Read(dataBuf)
+	return storeReply(resp)
+}
+
+func (m *Memcached) store(ctx context.Context, verb string, key Key, value string, flags uint32, ttl TTL) (string, error) {
+	cmd, err := m.storeCommand(verb, key, value, flags, ttl, "")
 	if err != nil {
 		return "", err
 	}
+	return m.commandContext(ctx, cmd)
+}
 
-	rnEof := "\r\n" + eof
-	rnEofLen := len(rnEof)
-	rnEofBuf := make([]byte, rnEofLen)
-	_, err = m.transport.Read(rnEofBuf)
+// SetNoReply does not wait for the server reply, so a failed store can
+// only be noticed through a later synchronous command.
+func (m *Memcached) SetNoReply(key Key, value string, ttl TTL) error {
+	cmd, err := m.storeCommand("set", key, value, 0, ttl, " noreply")
 	if err != nil {
-		return "", err
+		return err
+	}
+	return m.bestEffort(m.send(cmd))
+}
+
+func (m *Memcached) storeCommand(verb string, key Key, value string, flags uint32, ttl TTL, suffix string) (string, error) {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return "", validKeyErr
+	}
+	validTtlErr := ttl.isValid()
+	if validTtlErr != nil {
+		return "", validTtlErr
+	}
+	if verb != "append" && verb != "prepend" {
+		var encodeErr error
+		value, flags, encodeErr = m.encodeValue(value, flags)
+		if encodeErr != nil {
+			return "", encodeErr
+		}
 	}
-	line := string(rnEofBuf)
-	if line != rnEof {
-		return "", fmt.Errorf("unexpected end: %q\n", line)
+	validSizeErr := m.validValueSize(value)
+	if validSizeErr != nil {
+		return "", validSizeErr
 	}
 
-	return string(dataBuf), nil
+	return fmt.Sprintf("%s %s %d %d %d%s\r\n%s", verb, key, flags, ttl, len(value), suffix, value), nil
 }
 
-func (m *Memcached) Delete(key key) error {
-	validKeyErr := key.isValid()
+func (m *Memcached) validValueSize(value string) error {
+	if len(value) > m.maxValueSize {
+		return fmt.Errorf("value of %d bytes exceeds %d: %w", len(value), m.maxValueSize, ErrValueTooLarge)
+	}
+	return nil
+}
+
+func (m *Memcached) Get(key Key) (string, error) {
+	return m.GetContext(context.Background(), key)
+}
+
+func (m *Memcached) GetContext(ctx context.Context, key Key) (string, error) {
+	key, validKeyErr := m.validKey(key)
 	if validKeyErr != nil {
-		return validKeyErr
+		return "", validKeyErr
 	}
 
-	cmd := fmt.Sprintf("delete %s", key)
-	resp, err := m.command(cmd)
+	cmd := fmt.Sprintf("get %s", key)
+	value, err := m.retrieveOne(ctx, cmd)
 	if err != nil {
-		return err
+		return "", m.bestEffort(err)
 	}
-	if resp != "DELETED\r\n" {
-		return fmt.Errorf("delete failed: %q\n", resp)
-	}
-	return nil
+	return value, nil
 }
 
-func (m *Memcached) Close() {
-	m.transport.Close()
+func (m *Memcached) GetOK(key Key) (value string, found bool, err error) {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return "", false, validKeyErr
+	}
+
+	cmd := fmt.Sprintf("get %s", key)
+	items, err := m.retrieve(context.Background(), cmd)
+	err = m.bestEffort(err)
+	if err != nil {
+		return "", false, err
+	}
+	if len(items) == 0 {
+		return "", false, nil
+	}
+	return items[0].value, true, nil
 }
 
-func (m *Memcached) command(cmd string) (string, error) {
-	connectErr := m.transport.connect()
-	if connectErr != nil {
-		return "", connectErr
+// GetBytes is GetOK for binary values. The returned slice is the caller's
+// to modify, which costs a copy of the value read as a string; GetStream
+// avoids holding the whole value.
+func (m *Memcached) GetBytes(key Key) ([]byte, bool, error) {
+	value, found, err := m.GetOK(key)
+	if err != nil || !found {
+		return nil, found, err
 	}
+	return []byte(value), true, nil
+}
 
-	writeErr := m.transport.Write(cmd + "\r\n")
-	if writeErr != nil {
-		m.Close()
-		return "", fmt.Errorf("write error: %q\n", writeErr)
+func (m *Memcached) GetWithFlags(key Key) (value string, flags uint32, err error) {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return "", 0, validKeyErr
 	}
 
-	line, readErr := m.transport.Read([]byte{})
-	if readErr != nil {
-		m.Close()
-		return "", fmt.Errorf("read error: %q\n", readErr)
+	cmd := fmt.Sprintf("get %s", key)
+	items, err := m.retrieve(context.Background(), cmd)
+	err = m.bestEffort(err)
+	if err != nil {
+		return "", 0, err
 	}
-	if line == "ERROR\r\n" {
-		return "", fmt.Errorf("nonexistent command: %q\n", cmd)
+	if len(items) == 0 {
+		return "", 0, nil
 	}
-	if strings.HasPrefix(line, "CLIENT_ERROR ") || strings.HasPrefix(line, "SERVER_ERROR ") {
-		return "", fmt.Errorf("error: %q\n", line)
+	return items[0].value, items[0].flags, nil
+}
+
+func (m *Memcached) GetAndTouch(key Key, ttl TTL) (string, error) {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return "", validKeyErr
 	}
-	return line, nil
+	validTtlErr := ttl.isValid()
+	if validTtlErr != nil {
+		return "", validTtlErr
+	}
+
+	cmd := fmt.Sprintf("gat %d %s", ttl, key)
+	value, err := m.retrieveOne(context.Background(), cmd)
+	return value, m.bestEffort(err)
+}
+
+func (m *Memcached) GetMulti(keys []Key) (map[Key]string, error) {
+	items, err := m.retrieveMulti(context.Background(), keys)
+	err = m.bestEffort(err)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[Key]string, len(items))
+	for _, it := range items {
+		values[it.key] = it.value
+	}
+	return values, nil
+}
+
+// GetMultiContext returns the values read so far together with an error
+// wrapping ErrPartialResult when ctx ends in the middle of the reply.
+func (m *Memcached) GetMultiContext(ctx context.Context, keys []Key) (map[Key]string, error) {
+	items, err := m.retrieveMulti(ctx, keys)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+		return nil, err
+	}
+	values := make(map[Key]string, len(items))
+	for _, it := range items {
+		values[it.key] = it.value
+	}
+	if err != nil {
+		return values, fmt.Errorf("%w: %w", err, ErrPartialResult)
+	}
+	return values, nil
+}
+
+// GetMultiFunc stores and returns the value onMiss loads for a missing key;
+// a key onMiss reports as not ok stays missing.
+func (m *Memcached) GetMultiFunc(keys []Key, onMiss func(Key) (string, TTL, bool)) (map[Key]string, error) {
+	values, err := m.GetMulti(keys)
+	if err != nil {
+		return nil, err
+	}
+	if values == nil {
+		values = make(map[Key]string)
+	}
+	missed := make(map[Key]bool)
+	for _, key := range keys {
+		if _, found := values[key]; found || missed[key] {
+			continue
+		}
+		missed[key] = true
+		value, ttl, ok := onMiss(key)
+		if !ok {
+			continue
+		}
+		err := m.Set(key, value, ttl)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (m *Memcached) GetMultiWithFlags(keys []Key) (map[Key]struct {
+	Value string
+	Flags uint32
+}, error) {
+	items, err := m.retrieveMulti(context.Background(), keys)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[Key]struct {
+		Value string
+		Flags uint32
+	}, len(items))
+	for _, it := range items {
+		values[it.key] = struct {
+			Value string
+			Flags uint32
+		}{it.value, it.flags}
+	}
+	return values, nil
+}
+
+func (m *Memcached) GetMultiOrdered(keys []Key) ([]Result, error) {
+	items, err := m.retrieveMulti(context.Background(), keys)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[Key]string, len(items))
+	for _, it := range items {
+		values[it.key] = it.value
+	}
+	results := make([]Result, len(keys))
+	for i, key := range keys {
+		value, found := values[key]
+		results[i] = Result{Key: key, Value: value, Found: found}
+	}
+	return results, nil
+}
+
+func (m *Memcached) retrieveMulti(ctx context.Context, keys []Key) ([]item, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		key, validKeyErr := m.validKey(key)
+		if validKeyErr != nil {
+			return nil, validKeyErr
+		}
+		names = append(names, string(key))
+	}
+
+	var items []item
+	for _, cmd := range splitGetCommands("get", names, m.maxGetLine) {
+		batch, err := m.retrieve(ctx, cmd)
+		items = append(items, batch...)
+		if err != nil {
+			return items, err
+		}
+	}
+	return items, nil
+}
+
+func splitGetCommands(verb string, names []string, maxLine int) []string {
+	var cmds []string
+	cmd := verb
+	for _, name := range names {
+		if cmd != verb && len(cmd)+1+len(name) > maxLine {
+			cmds = append(cmds, cmd)
+			cmd = verb
+		}
+		cmd += " " + name
+	}
+	return append(cmds, cmd)
+}
+
+func (m *Memcached) Gets(key Key) (value string, casID uint64, err error) {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return "", 0, validKeyErr
+	}
+
+	cmd := fmt.Sprintf("gets %s", key)
+	items, err := m.retrieve(context.Background(), cmd)
+	err = m.bestEffort(err)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(items) == 0 {
+		return "", 0, nil
+	}
+	return items[0].value, items[0].cas, nil
+}
+
+func (m *Memcached) GetCas(key Key) (value string, cas uint64, found bool, err error) {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return "", 0, false, validKeyErr
+	}
+
+	cmd := fmt.Sprintf("gets %s", key)
+	items, err := m.retrieve(context.Background(), cmd)
+	err = m.bestEffort(err)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if len(items) == 0 {
+		return "", 0, false, nil
+	}
+	return items[0].value, items[0].cas, true, nil
+}
+
+func (m *Memcached) Cas(key Key, value string, ttl TTL, casID uint64) error {
+	cmd, err := m.storeCommand("cas", key, value, 0, ttl, fmt.Sprintf(" %d", casID))
+	if err != nil {
+		return err
+	}
+	resp, err := m.command(cmd)
+	if err != nil {
+		return m.bestEffort(err)
+	}
+	switch resp {
+	case "STORED\r\n":
+		return nil
+	case "EXISTS\r\n":
+		return ErrCASConflict
+	case "NOT_FOUND\r\n":
+		return ErrNotFound
+	}
+	return fmt.Errorf("value is not stored: %q: %w", resp, ErrNotStored)
+}
+
+type item struct {
+	key   Key
+	flags uint32
+	value string
+	cas   uint64
+}
+
+// parseValueHeader rejects anything but a VALUE line, such as a stray reply
+// left behind by an earlier command. The connection is then out of sync and
+// is closed when the error reaches the pool.
+func parseValueHeader(header string) (item, int, error) {
+	var it item
+	if !strings.HasPrefix(header, "VALUE ") || !strings.HasSuffix(header, "\r\n") {
+		return it, 0, &ProtocolError{Stage: "header", Line: header}
+	}
+	fields := strings.Fields(header)
+	if len(fields) < 4 || len(fields) > 5 {
+		return it, 0, &ProtocolError{Stage: "header", Line: header}
+	}
+	it.key = Key(fields[1])
+	flags, flagsErr := strconv.ParseUint(fields[2], 10, 32)
+	bytes, bytesErr := strconv.Atoi(fields[3])
+	if flagsErr != nil || bytesErr != nil || bytes < 0 {
+		return it, 0, &ProtocolError{Stage: "header", Line: header}
+	}
+	it.flags = uint32(flags)
+	if len(fields) == 5 {
+		cas, casErr := strconv.ParseUint(fields[4], 10, 64)
+		if casErr != nil {
+			return it, 0, &ProtocolError{Stage: "header", Line: header}
+		}
+		it.cas = cas
+	}
+	return it, bytes, nil
+}
+
+func (m *Memcached) retrieveOne(ctx context.Context, cmd string) (string, error) {
+	items, err := m.retrieve(ctx, cmd)
+	if err != nil {
+		return "", err
+	}
+	if len(items) == 0 {
+		return "", nil
+	}
+	return items[0].value, nil
+}
+
+func (m *Memcached) retrieve(ctx context.Context, cmd string) ([]item, error) {
+	var items []item
+	err := m.exec(ctx, verbOf(cmd), func(t Transport) error {
+		header, err := m.request(ctx, t, cmd)
+		if err != nil {
+			return err
+		}
+
+		for header != "END\r\n" {
+			it, bytes, err := parseValueHeader(header)
+			if err != nil {
+				return err
+			}
+			it.value, err = m.readBody(t, bytes)
+			if err != nil {
+				return err
+			}
+			it.key = Key(strings.TrimPrefix(string(it.key), m.prefix))
+			it.value, it.flags, err = decodeValue(it.value, it.flags)
+			if err != nil {
+				return err
+			}
+			items = append(items, it)
+
+			header, err = m.readLine(t)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return items, err
+	}
+	return items, nil
+}
+
+func (m *Memcached) readBody(t Transport, bytes int) (string, error) {
+	if m.lenient {
+		return m.readBodyLenient(t, bytes)
+	}
+	data, release, err := m.readPooled(t, bytes+2)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	if string(data[bytes:]) != "\r\n" {
+		return "", &ProtocolError{Stage: "terminator", Line: string(data[bytes:])}
+	}
+	return string(data[:bytes]), nil
+}
+
+type bufferedTransport interface {
+	readFull(buf []byte) error
+}
+
+// readPooled reads n bytes into a buffer of the WithBufferPool pool, which
+// release returns. The data must not be retained after release.
+func (m *Memcached) readPooled(t Transport, n int) ([]byte, func(), error) {
+	bt, ok := t.(bufferedTransport)
+	if m.bufferPool == nil || !ok {
+		data, err := t.ReadN(n)
+		return data, func() {}, err
+	}
+	buf, _ := m.bufferPool.Get().(*[]byte)
+	if buf == nil {
+		buf = new([]byte)
+	}
+	if cap(*buf) < n {
+		*buf = make([]byte, n)
+	}
+	data := (*buf)[:n]
+	release := func() { m.bufferPool.Put(buf) }
+	err := bt.readFull(data)
+	if err != nil {
+		release()
+		return nil, func() {}, err
+	}
+	return data, release, nil
+}
+
+func (m *Memcached) readBodyLenient(t Transport, bytes int) (string, error) {
+	data, err := t.ReadN(bytes + 1)
+	if err != nil {
+		return "", err
+	}
+	if data[bytes] == '\r' {
+		end, err := t.ReadN(1)
+		if err != nil {
+			return "", err
+		}
+		data = append(data, end...)
+	}
+	if end := string(data[bytes:]); end != "\n" && end != "\r\n" {
+		return "", &ProtocolError{Stage: "terminator", Line: end}
+	}
+	return string(data[:bytes]), nil
+}
+
+func (m *Memcached) Delete(key Key) error {
+	return m.DeleteContext(context.Background(), key)
+}
+
+func (m *Memcached) DeleteContext(ctx context.Context, key Key) error {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return validKeyErr
+	}
+
+	cmd := fmt.Sprintf("delete %s", key)
+	resp, err := m.commandContext(ctx, cmd)
+	if err != nil {
+		return m.bestEffort(err)
+	}
+	if resp == "NOT_FOUND\r\n" {
+		return fmt.Errorf("delete failed: %q: %w", resp, ErrNotFound)
+	}
+	if resp != "DELETED\r\n" {
+		return fmt.Errorf("delete failed: %q\n", resp)
+	}
+	return nil
+}
+
+func (m *Memcached) DeleteIfExists(key Key) error {
+	err := m.Delete(key)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (m *Memcached) Touch(key Key, ttl TTL) error {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return validKeyErr
+	}
+	validTtlErr := ttl.isValid()
+	if validTtlErr != nil {
+		return validTtlErr
+	}
+
+	cmd := fmt.Sprintf("touch %s %d", key, ttl)
+	resp, err := m.command(cmd)
+	if err != nil {
+		return m.bestEffort(err)
+	}
+	if resp == "NOT_FOUND\r\n" {
+		return ErrNotFound
+	}
+	if resp != "TOUCHED\r\n" {
+		return fmt.Errorf("touch failed: %q\n", resp)
+	}
+	return nil
+}
+
+// DeleteNoReply does not wait for the server reply, so a failed delete can
+// only be noticed through a later synchronous command.
+func (m *Memcached) DeleteNoReply(key Key) error {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return validKeyErr
+	}
+
+	cmd := fmt.Sprintf("delete %s noreply", key)
+	return m.bestEffort(m.send(cmd))
+}
+
+func (m *Memcached) Incr(key Key, delta uint64) (uint64, error) {
+	return m.arithmetic("incr", key, delta)
+}
+
+// Decr never goes below zero: memcached clamps the result at 0.
+func (m *Memcached) Decr(key Key, delta uint64) (uint64, error) {
+	return m.arithmetic("decr", key, delta)
+}
+
+func (m *Memcached) arithmetic(verb string, key Key, delta uint64) (uint64, error) {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return 0, validKeyErr
+	}
+
+	cmd := fmt.Sprintf("%s %s %d", verb, key, delta)
+	resp, err := m.command(cmd)
+	if err != nil {
+		return 0, m.bestEffort(err)
+	}
+	if resp == "NOT_FOUND\r\n" {
+		return 0, ErrNotFound
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(resp), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %s reply: %q\n", verb, resp)
+	}
+	return value, nil
+}
+
+func (m *Memcached) FlushAll() error {
+	return m.flushAll("flush_all")
+}
+
+func (m *Memcached) FlushAllDelay(delay TTL) error {
+	validTtlErr := delay.isValid()
+	if validTtlErr != nil {
+		return validTtlErr
+	}
+	return m.flushAll(fmt.Sprintf("flush_all %d", delay))
+}
+
+func (m *Memcached) flushAll(cmd string) error {
+	resp, err := m.command(cmd)
+	if err != nil {
+		return err
+	}
+	if resp != "OK\r\n" {
+		return fmt.Errorf("flush failed: %q\n", resp)
+	}
+	return nil
+}
+
+func (m *Memcached) SetMemLimit(megabytes int) error {
+	if megabytes <= 0 {
+		return fmt.Errorf("invalid memory limit: %d: %w", megabytes, ErrInvalidArgument)
+	}
+	resp, err := m.command(fmt.Sprintf("cache_memlimit %d", megabytes))
+	if err != nil {
+		return err
+	}
+	if resp != "OK\r\n" {
+		return fmt.Errorf("cache_memlimit failed: %q\n", resp)
+	}
+	return nil
+}
+
+// SlabReassign moves a page from slab class src to dst. A src of -1 lets
+// the server pick the class.
+func (m *Memcached) SlabReassign(src int, dst int) error {
+	if src < -1 || dst < 0 {
+		return fmt.Errorf("invalid slab classes: %d %d: %w", src, dst, ErrInvalidArgument)
+	}
+	resp, err := m.command(fmt.Sprintf("slabs reassign %d %d", src, dst))
+	if err != nil {
+		return err
+	}
+	if resp != "OK\r\n" {
+		return fmt.Errorf("slabs reassign failed: %q\n", resp)
+	}
+	return nil
+}
+
+func (m *Memcached) Verbosity(level int) error {
+	if level < 0 || level > 3 {
+		return fmt.Errorf("invalid verbosity level: %d: %w", level, ErrInvalidArgument)
+	}
+	resp, err := m.command(fmt.Sprintf("verbosity %d", level))
+	if err != nil {
+		return err
+	}
+	if resp != "OK\r\n" {
+		return fmt.Errorf("verbosity failed: %q\n", resp)
+	}
+	return nil
+}
+
+func (m *Memcached) Version() (string, error) {
+	resp, err := m.command("version")
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(resp, "VERSION ") {
+		return "", fmt.Errorf("unexpected version reply: %q\n", resp)
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(resp, "VERSION "), "\r\n"), nil
+}
+
+func (m *Memcached) Ping() error {
+	_, err := m.Version()
+	if err != nil {
+		return fmt.Errorf("ping failed: %q: %w", err, ErrUnreachable)
+	}
+	return nil
+}
+
+func (m *Memcached) Stats() (map[string]string, error) {
+	return m.stats("stats")
+}
+
+func (m *Memcached) StatsSub(section string) (map[string]string, error) {
+	if section == "" || strings.ContainsAny(section, " \t\r\n") {
+		return nil, fmt.Errorf("invalid stats section: %q: %w", section, ErrInvalidArgument)
+	}
+	return m.stats(fmt.Sprintf("stats %s", section))
+}
+
+// LRUCrawlerStatus reports the lru_crawler settings together with the
+// crawler counters from the general stats.
+func (m *Memcached) LRUCrawlerStatus() (map[string]string, error) {
+	return m.statsStatus("lru_crawler")
+}
+
+// SlabAutomoverStatus reports the slab_automove and slab_reassign settings
+// together with the slab move counters from the general stats.
+func (m *Memcached) SlabAutomoverStatus() (map[string]string, error) {
+	return m.statsStatus("slab")
+}
+
+func (m *Memcached) statsStatus(prefix string) (map[string]string, error) {
+	settings, err := m.StatsSub("settings")
+	if err != nil {
+		return nil, err
+	}
+	stats, err := m.Stats()
+	if err != nil {
+		return nil, err
+	}
+	status := make(map[string]string)
+	for _, section := range []map[string]string{settings, stats} {
+		for name, value := range section {
+			if strings.HasPrefix(name, prefix) {
+				status[name] = value
+			}
+		}
+	}
+	return status, nil
+}
+
+func (m *Memcached) stats(cmd string) (map[string]string, error) {
+	lines, err := m.commandMultiline(cmd, "END")
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]string, len(lines))
+	for _, line := range lines {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 || fields[0] != "STAT" {
+			return nil, fmt.Errorf("cannot parse stat: %q\n", line)
+		}
+		stats[fields[1]] = fields[2]
+	}
+	return stats, nil
+}
+
+// commandMultiline returns the reply lines without their "\r\n", up to but
+// not including the terminator line. An error line ends the reply early.
+func (m *Memcached) commandMultiline(cmd string, terminator string) ([]string, error) {
+	var lines []string
+	err := m.exec(context.Background(), verbOf(cmd), func(t Transport) error {
+		line, err := m.request(context.Background(), t, cmd)
+		if err != nil {
+			return err
+		}
+
+		for line != terminator+"\r\n" {
+			lines = append(lines, strings.TrimSuffix(line, "\r\n"))
+
+			line, err = m.readLine(t)
+			if err != nil {
+				return err
+			}
+			replyErr := replyError(cmd, line)
+			if replyErr != nil {
+				return replyErr
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+func (m *Memcached) Close() {
+	m.closeIdle()
+}
+
+// Reconnect closes the idle connections and dials a fresh one. Connections
+// in use by concurrent calls are kept until they are returned broken.
+func (m *Memcached) Reconnect() error {
+	m.closeIdle()
+	return m.exec(context.Background(), "connect", func(t Transport) error {
+		m.setPending(t, false)
+		t.Close()
+		return t.Connect()
+	})
+}
+
+func (m *Memcached) closeIdle() {
+	for _, t := range m.pool.takeIdle() {
+		m.setPending(t, false)
+		m.pool.put(t, true)
+	}
+}
+
+func (m *Memcached) connect() error {
+	return m.exec(context.Background(), "connect", func(t Transport) error {
+		return t.Connect()
+	})
+}
+
+func (m *Memcached) Quit() error {
+	var quitErr error
+	for _, t := range m.pool.takeIdle() {
+		err := t.Write("quit\r\n")
+		if err == nil {
+			err = t.Flush()
+		}
+		if err != nil && !errors.Is(err, net.ErrClosed) && quitErr == nil {
+			quitErr = fmt.Errorf("quit failed: %q\n", err)
+		}
+		m.setPending(t, false)
+		m.pool.put(t, true)
+	}
+	return quitErr
+}
+
+func (m *Memcached) exec(ctx context.Context, op string, fn func(t Transport) error) error {
+	return m.execHeld(ctx, op, func(t Transport) (bool, error) {
+		return false, fn(t)
+	})
+}
+
+// execHeld does not return the transport to the pool when fn reports that
+// it still holds it; the holder hands it back with release.
+func (m *Memcached) execHeld(ctx context.Context, op string, fn func(t Transport) (held bool, err error)) error {
+	start := time.Now()
+	err := m.execTransport(ctx, fn)
+	m.counters.commands.Add(1)
+	if err != nil && !isReplyError(err) {
+		m.counters.errors.Add(1)
+	}
+	if m.observer != nil {
+		m.observer.ObserveOp(op, time.Since(start), err)
+	}
+	return err
+}
+
+func (m *Memcached) execTransport(ctx context.Context, fn func(t Transport) (bool, error)) error {
+	t, idle, err := m.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	if m.idleCheck > 0 && idle > m.idleCheck {
+		m.probe(t)
+	}
+	var commandDeadline time.Time
+	if m.commandTimeout > 0 {
+		commandDeadline = time.Now().Add(m.commandTimeout)
+	}
+	if ctx.Done() == nil {
+		if !commandDeadline.IsZero() {
+			t.SetDeadline(commandDeadline)
+		}
+		held, err := fn(t)
+		if !commandDeadline.IsZero() {
+			t.SetDeadline(time.Time{})
+		}
+		if !held {
+			m.release(t, err)
+		}
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && (commandDeadline.IsZero() || deadline.Before(commandDeadline)) {
+		t.SetDeadline(deadline)
+	} else if !commandDeadline.IsZero() {
+		t.SetDeadline(commandDeadline)
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			t.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	held, err := fn(t)
+	close(stop)
+	<-stopped
+	t.SetDeadline(time.Time{})
+
+	if err != nil {
+		ctxErr := contextError(ctx, err)
+		if ctxErr != nil {
+			t.Close()
+			err = ctxErr
+		}
+	}
+	if !held {
+		m.release(t, err)
+	}
+	return err
+}
+
+// release returns t to the pool. A broken transport is closed, so no
+// noreply output is left to reconcile on it.
+func (m *Memcached) release(t Transport, err error) {
+	broken := err != nil && !isReplyError(err)
+	if broken {
+		m.setPending(t, false)
+		m.counters.dropped()
+	}
+	m.pool.put(t, broken)
+}
+
+// contextError also covers the socket timing out on the ctx deadline just
+// before ctx itself reports it.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	deadline, ok := ctx.Deadline()
+	if ok && errors.Is(err, ErrTimeout) && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// bestEffort drops connection, protocol and server errors in best effort
+// mode so that reads behave like a miss and writes like a lost write. Bad
+// arguments and replies such as a miss are still returned.
+func (m *Memcached) bestEffort(err error) error {
+	if !m.bestEffortMode || isReplyError(err) || errors.Is(err, ErrInvalidArgument) || errors.Is(err, ErrValueTooLarge) {
+		return err
+	}
+	if m.logger != nil {
+		m.logger.Printf("memcached: ignored error: %q", err)
+	}
+	return nil
+}
+
+func (m *Memcached) probe(t Transport) {
+	_, _, err := m.roundTrip(t, "version")
+	if err != nil {
+		t.Close()
+	}
+}
+
+func (m *Memcached) send(cmd string) error {
+	return m.exec(context.Background(), verbOf(cmd), func(t Transport) error {
+		err := m.write(t, cmd)
+		if err == nil {
+			m.setPending(t, true)
+		}
+		return err
+	})
+}
+
+func (m *Memcached) command(cmd string) (string, error) {
+	return m.commandContext(context.Background(), cmd)
+}
+
+func (m *Memcached) commandContext(ctx context.Context, cmd string) (string, error) {
+	var line string
+	err := m.exec(ctx, verbOf(cmd), func(t Transport) error {
+		var err error
+		line, err = m.request(ctx, t, cmd)
+		return err
+	})
+	return line, err
+}
+
+func (m *Memcached) request(ctx context.Context, t Transport, cmd string) (string, error) {
+	line, sent, err := m.roundTrip(t, cmd)
+	for attempt := 1; err != nil && attempt < m.retryAttempts() && m.retryable(cmd, sent, err); attempt++ {
+		waitErr := m.retryWait(ctx, attempt)
+		if waitErr != nil {
+			return "", waitErr
+		}
+		line, sent, err = m.roundTrip(t, cmd)
+	}
+	if err != nil {
+		return "", err
+	}
+	replyErr := replyError(cmd, line)
+	if replyErr != nil {
+		return "", replyErr
+	}
+	return line, nil
+}
+
+func replyError(cmd string, line string) error {
+	if line == "ERROR\r\n" {
+		return fmt.Errorf("nonexistent command: %q: %w", commandLine(cmd), ErrServerError)
+	}
+	for _, kind := range []string{"CLIENT_ERROR", "SERVER_ERROR"} {
+		if strings.HasPrefix(line, kind+" ") {
+			message := strings.TrimSpace(strings.TrimPrefix(line, kind+" "))
+			return &ServerError{Kind: kind, Message: message}
+		}
+	}
+	word, _, _ := strings.Cut(strings.TrimSuffix(line, "\r\n"), " ")
+	switch word {
+	case "BUSY":
+		return fmt.Errorf("server is busy: %q: %w", line, ErrBusy)
+	case "BADCLASS":
+		return fmt.Errorf("bad slab class: %q: %w", line, ErrBadClass)
+	}
+	return nil
+}
+
+type ServerError struct {
+	Kind    string
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("error: %q\n", e.Kind+" "+e.Message+"\r\n")
+}
+
+func (e *ServerError) Unwrap() error {
+	return ErrServerError
+}
+
+// ProtocolError is a reply that cannot be parsed. Stage is one of header,
+// body or terminator.
+type ProtocolError struct {
+	Stage string
+	Line  string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("cannot parse %s: %q\n", e.Stage, e.Line)
+}
+
+// roundTrip reports whether cmd was written, after which the server may
+// have executed it even though no reply arrived.
+func (m *Memcached) roundTrip(t Transport, cmd string) (line string, sent bool, err error) {
+	reconcileErr := m.reconcile(t)
+	if reconcileErr != nil {
+		return "", false, reconcileErr
+	}
+	writeErr := m.write(t, cmd)
+	if writeErr != nil {
+		return "", false, writeErr
+	}
+	line, err = m.readLine(t)
+	if m.logger != nil && err == nil {
+		m.logger.Printf("memcached: < %q", line)
+	}
+	return line, true, err
+}
+
+func (m *Memcached) write(t Transport, cmd string) error {
+	connectErr := t.Connect()
+	if connectErr != nil {
+		return connectErr
+	}
+
+	if m.logger != nil {
+		m.logger.Printf("memcached: > %s", commandLine(cmd))
+	}
+	writeErr := t.Write(cmd + "\r\n")
+	if writeErr == nil {
+		writeErr = t.Flush()
+	}
+	if writeErr != nil {
+		t.Close()
+		if writeErr == ErrTimeout {
+			return writeErr
+		}
+		return fmt.Errorf("write error: %w\n", writeErr)
+	}
+	return nil
+}
+
+func isConnError(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+func (m *Memcached) readLine(t Transport) (string, error) {
+	line, readErr := t.ReadLine()
+	if readErr != nil {
+		t.Close()
+		if readErr == ErrTimeout {
+			return "", readErr
+		}
+		return "", fmt.Errorf("read error: %w\n", readErr)
+	}
+	if m.lenient {
+		line = strings.TrimRight(line, " \t\r\n") + "\r\n"
+	}
+	return line, nil
+}
+
+func storeReply(resp string) error {
+	if resp == "NOT_STORED\r\n" {
+		return ErrNotStored
+	}
+	if resp != "STORED\r\n" {
+		return fmt.Errorf("value is not stored: %q: %w", resp, ErrNotStored)
+	}
+	return nil
 }
@@ -0,0 +1,2669 @@
+package memcached
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// memServer is a small in-memory memcached speaking the text, meta and the
+// binary get/set/delete/SASL commands. Tests reach it through a
+// mockTransport or through a TCP listener started by serveTCP.
+type memServer struct {
+	mu       sync.Mutex
+	items    map[string]*memItem
+	lastCAS  uint64
+	now      func() time.Time
+	noMeta   bool
+	saslUser string
+	saslPass string
+	// hook answers a command line before the server does when it returns
+	// true. The lock is held while it runs.
+	hook  func(line string) (string, bool)
+	delay time.Duration
+	// chunk splits every reply into writes of at most that many bytes.
+	chunk int
+	lines []string
+	conns atomic.Int32
+}
+
+type memItem struct {
+	value   []byte
+	flags   uint32
+	exp     int64
+	cas     uint64
+	fetched bool
+	access  int64
+}
+
+func newMemServer() *memServer {
+	return &memServer{items: make(map[string]*memItem), now: time.Now}
+}
+
+// received returns the command lines the server has seen, binary requests
+// by their opcode.
+func (s *memServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.lines...)
+}
+
+func (s *memServer) put(key string, value string, flags uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store(key, []byte(value), flags, 0)
+}
+
+func (s *memServer) item(key string) (*memItem, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookup(key)
+}
+
+func (s *memServer) lookup(key string) (*memItem, bool) {
+	it, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	if it.exp != 0 && s.now().Unix() >= it.exp {
+		delete(s.items, key)
+		return nil, false
+	}
+	return it, true
+}
+
+func (s *memServer) store(key string, value []byte, flags uint32, ttl int64) *memItem {
+	s.lastCAS++
+	it := &memItem{value: append([]byte(nil), value...), flags: flags, exp: s.expiry(ttl), cas: s.lastCAS, access: s.now().Unix()}
+	s.items[key] = it
+	return it
+}
+
+func (s *memServer) expiry(ttl int64) int64 {
+	switch {
+	case ttl == 0:
+		return 0
+	case ttl < 0:
+		return s.now().Unix() - 1
+	case ttl > int64(maxRelativeTTL):
+		return ttl
+	}
+	return s.now().Unix() + ttl
+}
+
+type memSession struct {
+	srv    *memServer
+	buf    []byte
+	authed bool
+}
+
+func (s *memServer) session() *memSession {
+	return &memSession{srv: s}
+}
+
+// feed consumes every complete command in data and the bytes left over from
+// earlier calls, and returns the replies.
+func (c *memSession) feed(data []byte) []byte {
+	c.buf = append(c.buf, data...)
+	var out bytes.Buffer
+	for len(c.buf) > 0 {
+		if c.buf[0] == binaryRequestMagic {
+			n, reply := c.binary()
+			if n == 0 {
+				break
+			}
+			c.buf = c.buf[n:]
+			out.Write(reply)
+			continue
+		}
+		i := bytes.IndexByte(c.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(c.buf[:i]), "\r")
+		fields := strings.Fields(line)
+		n := i + 1
+		var body []byte
+		if size, ok := bodySize(fields); ok {
+			if len(c.buf) < n+size+2 {
+				break
+			}
+			body = c.buf[n : n+size]
+			n += size + 2
+		}
+		c.buf = c.buf[n:]
+		if c.srv.saslUser != "" {
+			out.WriteString("CLIENT_ERROR unauthenticated\r\n")
+			continue
+		}
+		out.WriteString(c.srv.exec(line, fields, body))
+	}
+	return out.Bytes()
+}
+
+func bodySize(fields []string) (int, bool) {
+	if len(fields) == 0 {
+		return 0, false
+	}
+	index := -1
+	switch fields[0] {
+	case "set", "add", "replace", "append", "prepend", "cas":
+		index = 4
+	case "ms":
+		index = 2
+	}
+	if index < 0 || len(fields) <= index {
+		return 0, false
+	}
+	size, err := strconv.Atoi(fields[index])
+	return size, err == nil && size >= 0
+}
+
+func (s *memServer) exec(line string, f []string, body []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = append(s.lines, line)
+	if s.hook != nil {
+		if reply, ok := s.hook(line); ok {
+			return reply
+		}
+	}
+	if len(f) == 0 {
+		return "ERROR\r\n"
+	}
+	noreply := f[len(f)-1] == "noreply"
+	quiet := func(reply string) string {
+		if noreply {
+			return ""
+		}
+		return reply
+	}
+	switch f[0] {
+	case "get", "gets":
+		return s.retrieve(f[1:], f[0] == "gets", nil)
+	case "gat", "gats":
+		if len(f) < 3 {
+			return "ERROR\r\n"
+		}
+		ttl, _ := strconv.ParseInt(f[1], 10, 64)
+		return s.retrieve(f[2:], f[0] == "gats", &ttl)
+	case "set", "add", "replace", "append", "prepend", "cas":
+		if len(f) < 5 {
+			return "ERROR\r\n"
+		}
+		flags, _ := strconv.ParseUint(f[2], 10, 32)
+		ttl, _ := strconv.ParseInt(f[3], 10, 64)
+		it, found := s.lookup(f[1])
+		switch f[0] {
+		case "add":
+			if found {
+				return quiet("NOT_STORED\r\n")
+			}
+		case "replace":
+			if !found {
+				return quiet("NOT_STORED\r\n")
+			}
+		case "append", "prepend":
+			if !found {
+				return quiet("NOT_STORED\r\n")
+			}
+			if f[0] == "append" {
+				body = append(append([]byte(nil), it.value...), body...)
+			} else {
+				body = append(append([]byte(nil), body...), it.value...)
+			}
+			flags, ttl = uint64(it.flags), 0
+			exp := it.exp
+			s.store(f[1], body, uint32(flags), ttl).exp = exp
+			return quiet("STORED\r\n")
+		case "cas":
+			if len(f) < 6 {
+				return "ERROR\r\n"
+			}
+			if !found {
+				return quiet("NOT_FOUND\r\n")
+			}
+			if strconv.FormatUint(it.cas, 10) != f[5] {
+				return quiet("EXISTS\r\n")
+			}
+		}
+		s.store(f[1], body, uint32(flags), ttl)
+		return quiet("STORED\r\n")
+	case "delete":
+		if len(f) < 2 {
+			return "ERROR\r\n"
+		}
+		if _, found := s.lookup(f[1]); !found {
+			return quiet("NOT_FOUND\r\n")
+		}
+		delete(s.items, f[1])
+		return quiet("DELETED\r\n")
+	case "incr", "decr":
+		if len(f) < 3 {
+			return "ERROR\r\n"
+		}
+		it, found := s.lookup(f[1])
+		if !found {
+			return quiet("NOT_FOUND\r\n")
+		}
+		value, err := strconv.ParseUint(string(it.value), 10, 64)
+		delta, deltaErr := strconv.ParseUint(f[2], 10, 64)
+		if err != nil || deltaErr != nil {
+			return "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"
+		}
+		if f[0] == "incr" {
+			value += delta
+		} else if delta > value {
+			value = 0
+		} else {
+			value -= delta
+		}
+		it.value = []byte(strconv.FormatUint(value, 10))
+		return quiet(string(it.value) + "\r\n")
+	case "touch":
+		if len(f) < 3 {
+			return "ERROR\r\n"
+		}
+		it, found := s.lookup(f[1])
+		if !found {
+			return quiet("NOT_FOUND\r\n")
+		}
+		ttl, _ := strconv.ParseInt(f[2], 10, 64)
+		it.exp = s.expiry(ttl)
+		return quiet("TOUCHED\r\n")
+	case "flush_all":
+		if len(f) == 1 || f[1] == "0" || f[1] == "noreply" {
+			s.items = make(map[string]*memItem)
+		} else {
+			delay, _ := strconv.ParseInt(f[1], 10, 64)
+			for _, it := range s.items {
+				it.exp = s.now().Unix() + delay
+			}
+		}
+		return quiet("OK\r\n")
+	case "version":
+		return "VERSION 1.6.21\r\n"
+	case "verbosity", "cache_memlimit":
+		if len(f) < 2 {
+			return "ERROR\r\n"
+		}
+		return quiet("OK\r\n")
+	case "stats":
+		return s.stats(f[1:])
+	case "slabs":
+		return "OK\r\n"
+	case "lru_crawler":
+		if len(f) == 3 && f[1] == "metadump" {
+			return s.metadump()
+		}
+		return "ERROR\r\n"
+	case "quit":
+		return ""
+	case "mn", "mg", "ms", "md", "ma":
+		if s.noMeta {
+			return "ERROR\r\n"
+		}
+		return s.meta(f, body)
+	}
+	return "ERROR\r\n"
+}
+
+func (s *memServer) retrieve(keys []string, withCAS bool, ttl *int64) string {
+	var out strings.Builder
+	for _, key := range keys {
+		it, found := s.lookup(key)
+		if !found {
+			continue
+		}
+		if ttl != nil {
+			it.exp = s.expiry(*ttl)
+		}
+		it.fetched = true
+		it.access = s.now().Unix()
+		if withCAS {
+			fmt.Fprintf(&out, "VALUE %s %d %d %d\r\n", key, it.flags, len(it.value), it.cas)
+		} else {
+			fmt.Fprintf(&out, "VALUE %s %d %d\r\n", key, it.flags, len(it.value))
+		}
+		out.Write(it.value)
+		out.WriteString("\r\n")
+	}
+	out.WriteString("END\r\n")
+	return out.String()
+}
+
+func (s *memServer) stats(args []string) string {
+	if len(args) == 0 {
+		return fmt.Sprintf("STAT pid 1\r\nSTAT curr_items %d\r\nSTAT evictions 0\r\nSTAT lru_crawler_running 0\r\nSTAT slabs_moved 4\r\nEND\r\n", len(s.items))
+	}
+	switch args[0] {
+	case "settings":
+		return "STAT maxbytes 67108864\r\nSTAT item_size_max 1048576\r\nSTAT slab_reassign yes\r\nSTAT slab_automove 1\r\nSTAT lru_crawler yes\r\nEND\r\n"
+	case "items":
+		return fmt.Sprintf("STAT items:1:number %d\r\nSTAT items:1:age 10\r\nEND\r\n", len(s.items))
+	case "slabs":
+		return "STAT 1:chunk_size 96\r\nSTAT active_slabs 1\r\nEND\r\n"
+	case "sizes":
+		return "STAT 96 1\r\nEND\r\n"
+	}
+	return "ERROR\r\n"
+}
+
+func (s *memServer) metadump() string {
+	var out strings.Builder
+	for key, it := range s.items {
+		exp := it.exp
+		if exp == 0 {
+			exp = -1
+		}
+		fetch := "no"
+		if it.fetched {
+			fetch = "yes"
+		}
+		fmt.Fprintf(&out, "key=%s exp=%d la=%d cas=%d fetch=%s cls=1 size=%d\r\n", url.QueryEscape(key), exp, it.access, it.cas, fetch, len(it.value))
+	}
+	out.WriteString("END\r\n")
+	return out.String()
+}
+
+func (s *memServer) meta(f []string, body []byte) string {
+	if f[0] == "mn" {
+		return "MN\r\n"
+	}
+	if len(f) < 2 {
+		return "CLIENT_ERROR bad command line format\r\n"
+	}
+	key := f[1]
+	flags := f[2:]
+	if f[0] == "ms" {
+		flags = f[3:]
+	}
+	has := func(c byte) (string, bool) {
+		for _, flag := range flags {
+			if flag[0] == c {
+				return flag[1:], true
+			}
+		}
+		return "", false
+	}
+	_, q := has('q')
+	it, found := s.lookup(key)
+	switch f[0] {
+	case "mg":
+		if !found {
+			if q {
+				return ""
+			}
+			return "EN\r\n"
+		}
+		var ret []string
+		_, withValue := has('v')
+		for _, flag := range flags {
+			switch flag[0] {
+			case 't':
+				if it.exp == 0 {
+					ret = append(ret, "t-1")
+				} else {
+					ret = append(ret, fmt.Sprintf("t%d", it.exp-s.now().Unix()))
+				}
+			case 'f':
+				ret = append(ret, fmt.Sprintf("f%d", it.flags))
+			case 'c':
+				ret = append(ret, fmt.Sprintf("c%d", it.cas))
+			case 'k':
+				ret = append(ret, "k"+key)
+			case 's':
+				ret = append(ret, fmt.Sprintf("s%d", len(it.value)))
+			case 'l':
+				ret = append(ret, fmt.Sprintf("l%d", s.now().Unix()-it.access))
+			case 'h':
+				if it.fetched {
+					ret = append(ret, "h1")
+				} else {
+					ret = append(ret, "h0")
+				}
+			}
+		}
+		it.fetched = true
+		it.access = s.now().Unix()
+		tail := ""
+		if len(ret) > 0 {
+			tail = " " + strings.Join(ret, " ")
+		}
+		if withValue {
+			return fmt.Sprintf("VA %d%s\r\n%s\r\n", len(it.value), tail, it.value)
+		}
+		return "HD" + tail + "\r\n"
+	case "ms":
+		var itemFlags uint32
+		var ttl int64
+		if v, ok := has('F'); ok {
+			n, _ := strconv.ParseUint(v, 10, 32)
+			itemFlags = uint32(n)
+		}
+		if v, ok := has('T'); ok {
+			ttl, _ = strconv.ParseInt(v, 10, 64)
+		}
+		if v, ok := has('C'); ok {
+			if !found {
+				return "NF\r\n"
+			}
+			if strconv.FormatUint(it.cas, 10) != v {
+				return "EX\r\n"
+			}
+		}
+		if v, ok := has('M'); ok && (v == "E" || v == "e") && found {
+			return "NS\r\n"
+		}
+		stored := s.store(key, body, itemFlags, ttl)
+		if q {
+			return ""
+		}
+		if _, ok := has('c'); ok {
+			return fmt.Sprintf("HD c%d\r\n", stored.cas)
+		}
+		return "HD\r\n"
+	case "md":
+		if !found && q {
+			return ""
+		}
+		if !found {
+			return "NF\r\n"
+		}
+		if v, ok := has('C'); ok && strconv.FormatUint(it.cas, 10) != v {
+			return "EX\r\n"
+		}
+		delete(s.items, key)
+		if q {
+			return ""
+		}
+		return "HD\r\n"
+	case "ma":
+		if ttl, ok := has('N'); !found && ok {
+			initial, _ := has('J')
+			if initial == "" {
+				initial = "0"
+			}
+			vivify, _ := strconv.ParseInt(ttl, 10, 64)
+			it, found = s.store(key, []byte(initial), 0, vivify), true
+			if _, ok := has('v'); ok {
+				return fmt.Sprintf("VA %d\r\n%s\r\n", len(it.value), it.value)
+			}
+			return "HD\r\n"
+		}
+		if !found {
+			return "NF\r\n"
+		}
+		value, err := strconv.ParseUint(string(it.value), 10, 64)
+		if err != nil {
+			return "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"
+		}
+		delta := uint64(1)
+		if v, ok := has('D'); ok {
+			delta, _ = strconv.ParseUint(v, 10, 64)
+		}
+		if mode, _ := has('M'); mode == "D" || mode == "d" || mode == "-" {
+			if delta > value {
+				value = 0
+			} else {
+				value -= delta
+			}
+		} else {
+			value += delta
+		}
+		it.value = []byte(strconv.FormatUint(value, 10))
+		if _, ok := has('v'); ok {
+			return fmt.Sprintf("VA %d\r\n%s\r\n", len(it.value), it.value)
+		}
+		if q {
+			return ""
+		}
+		return "HD\r\n"
+	}
+	return "ERROR\r\n"
+}
+
+// binary answers one binary request from the session buffer and reports how
+// many bytes it used, zero while the request is incomplete.
+func (c *memSession) binary() (int, []byte) {
+	if len(c.buf) < binaryHeaderLen {
+		return 0, nil
+	}
+	bodyLen := int(binary.BigEndian.Uint32(c.buf[8:12]))
+	if len(c.buf) < binaryHeaderLen+bodyLen {
+		return 0, nil
+	}
+	keyLen := int(binary.BigEndian.Uint16(c.buf[2:4]))
+	extrasLen := int(c.buf[4])
+	req := &binaryPacket{
+		opcode: c.buf[1],
+		opaque: binary.BigEndian.Uint32(c.buf[12:16]),
+		extras: c.buf[binaryHeaderLen : binaryHeaderLen+extrasLen],
+		key:    c.buf[binaryHeaderLen+extrasLen : binaryHeaderLen+extrasLen+keyLen],
+		value:  c.buf[binaryHeaderLen+extrasLen+keyLen : binaryHeaderLen+bodyLen],
+	}
+
+	s := c.srv
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = append(s.lines, binaryOpName(req.opcode)+" "+string(req.key))
+	resp := &binaryPacket{magic: binaryResponseMagic, opcode: req.opcode, opaque: req.opaque}
+	switch {
+	case req.opcode == opSASLAuth:
+		if string(req.key) == "PLAIN" && string(req.value) == "\x00"+s.saslUser+"\x00"+s.saslPass {
+			c.authed = true
+			resp.value = []byte("Authenticated")
+		} else {
+			resp.status = statusAuthFailed
+			resp.value = []byte("Auth failure")
+		}
+	case s.saslUser != "" && !c.authed:
+		resp.status = statusAuthFailed
+	case req.opcode == opSet:
+		flags := binary.BigEndian.Uint32(req.extras[0:4])
+		ttl := int64(binary.BigEndian.Uint32(req.extras[4:8]))
+		resp.cas = s.store(string(req.key), req.value, flags, ttl).cas
+	case req.opcode == opGet || req.opcode == opGetK || req.opcode == opGetKQ:
+		it, found := s.lookup(string(req.key))
+		if !found {
+			if req.opcode == opGetKQ {
+				return binaryHeaderLen + bodyLen, nil
+			}
+			resp.status = statusKeyNotFound
+			resp.value = []byte("Not found")
+			break
+		}
+		resp.extras = make([]byte, 4)
+		binary.BigEndian.PutUint32(resp.extras, it.flags)
+		if req.opcode != opGet {
+			resp.key = req.key
+		}
+		resp.value = it.value
+		resp.cas = it.cas
+	case req.opcode == opDelete:
+		if _, found := s.lookup(string(req.key)); !found {
+			resp.status = statusKeyNotFound
+			break
+		}
+		delete(s.items, string(req.key))
+	default:
+		resp.status = 0x0081
+		resp.value = []byte("Unknown command")
+	}
+	return binaryHeaderLen + bodyLen, resp.encode()
+}
+
+// serveTCP serves srv on a loopback listener until the test ends.
+func serveTCP(t testing.TB, srv *memServer) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveListener(t, ln, srv)
+}
+
+// serveListener serves srv on ln until the test ends.
+func serveListener(t testing.TB, ln net.Listener, srv *memServer) string {
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			srv.conns.Add(1)
+			go func() {
+				defer conn.Close()
+				session := srv.session()
+				buf := make([]byte, 64*1024)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						return
+					}
+					reply := session.feed(buf[:n])
+					srv.mu.Lock()
+					delay, chunk := srv.delay, srv.chunk
+					srv.mu.Unlock()
+					if delay > 0 && len(reply) > 0 {
+						time.Sleep(delay)
+					}
+					for chunk > 0 && len(reply) > chunk {
+						if _, err := conn.Write(reply[:chunk]); err != nil {
+							return
+						}
+						reply = reply[chunk:]
+						time.Sleep(time.Millisecond)
+					}
+					if _, err := conn.Write(reply); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// serveCanned answers every command line on a loopback listener with reply.
+func serveCanned(t *testing.T, reply string) string {
+	t.Helper()
+	srv := newMemServer()
+	srv.hook = func(string) (string, bool) { return reply, true }
+	return serveTCP(t, srv)
+}
+
+// serveSilent accepts connections on a loopback listener and never answers,
+// counting the connections in accepted.
+func serveSilent(t *testing.T, accepted *atomic.Int32) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			go func() {
+				defer conn.Close()
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// mockTransport is a Transport without a network. Every Flush hands the
+// written bytes to the handler of the current connection, and its replies
+// are what ReadLine and ReadN return. Reading past them yields io.EOF, as
+// from a server that hung up. Errors queued in connectErrs, flushErrs and
+// readErrs are returned by successive calls, nil entries let a call pass.
+type mockTransport struct {
+	dial        func() func(data []byte) []byte
+	handler     func(data []byte) []byte
+	connected   bool
+	connects    int
+	closes      int
+	out         []byte
+	in          bytes.Buffer
+	written     bytes.Buffer
+	reads       int
+	stalled     int // reads attempted with unflushed writes
+	counters    *connCounters
+	deadline    time.Time
+	deadlines   []time.Time
+	connectErrs []error
+	flushErrs   []error
+	readErrs    []error
+}
+
+func newMockTransport(srv *memServer) *mockTransport {
+	return &mockTransport{dial: func() func([]byte) []byte { return srv.session().feed }}
+}
+
+// newScriptTransport answers the flushes in order with replies, sharing the
+// script across reconnects.
+func newScriptTransport(replies ...string) *mockTransport {
+	handler := func([]byte) []byte {
+		if len(replies) == 0 {
+			return nil
+		}
+		reply := replies[0]
+		replies = replies[1:]
+		return []byte(reply)
+	}
+	return &mockTransport{dial: func() func([]byte) []byte { return handler }}
+}
+
+func popErr(errs *[]error) error {
+	if len(*errs) == 0 {
+		return nil
+	}
+	err := (*errs)[0]
+	*errs = (*errs)[1:]
+	return err
+}
+
+func (t *mockTransport) Connect() error {
+	if t.connected {
+		return nil
+	}
+	if err := popErr(&t.connectErrs); err != nil {
+		return fmt.Errorf("cannot connect: %q: %w", err, ErrUnreachable)
+	}
+	t.counters.dialed(t.connects > 0)
+	t.connected = true
+	t.connects++
+	t.handler = t.dial()
+	return nil
+}
+
+func (t *mockTransport) setCounters(c *connCounters) {
+	t.counters = c
+}
+
+func (t *mockTransport) Close() {
+	if t.connected {
+		t.closes++
+	}
+	t.connected = false
+	t.out = nil
+	t.in.Reset()
+}
+
+func (t *mockTransport) Write(data string) error {
+	if !t.connected {
+		return net.ErrClosed
+	}
+	t.out = append(t.out, data...)
+	return nil
+}
+
+func (t *mockTransport) Flush() error {
+	if !t.connected {
+		return net.ErrClosed
+	}
+	if err := popErr(&t.flushErrs); err != nil {
+		t.out = nil
+		return err
+	}
+	out := t.out
+	t.out = nil
+	t.written.Write(out)
+	t.in.Write(t.handler(out))
+	return nil
+}
+
+func (t *mockTransport) ReadLine() (string, error) {
+	if !t.connected {
+		return "", net.ErrClosed
+	}
+	t.reads++
+	if len(t.out) > 0 {
+		t.stalled++
+	}
+	if err := popErr(&t.readErrs); err != nil {
+		return "", err
+	}
+	line, err := t.in.ReadString('\n')
+	if err != nil {
+		return "", io.EOF
+	}
+	return line, nil
+}
+
+func (t *mockTransport) ReadN(n int) ([]byte, error) {
+	if !t.connected {
+		return nil, net.ErrClosed
+	}
+	t.reads++
+	if len(t.out) > 0 {
+		t.stalled++
+	}
+	if err := popErr(&t.readErrs); err != nil {
+		return nil, err
+	}
+	if t.in.Len() < n {
+		t.in.Reset()
+		return nil, io.ErrUnexpectedEOF
+	}
+	return t.in.Next(n), nil
+}
+
+func (t *mockTransport) SetDeadline(deadline time.Time) {
+	t.deadline = deadline
+	t.deadlines = append(t.deadlines, deadline)
+}
+
+// newTestClient returns a client talking to a fresh memServer through a
+// mockTransport.
+func newTestClient(t *testing.T, opts ...Option) (*Memcached, *memServer, *mockTransport) {
+	t.Helper()
+	srv := newMemServer()
+	tr := newMockTransport(srv)
+	return NewMemcachedWithTransport(tr, opts...), srv, tr
+}
+
+// newTCPClient returns a client of a fresh memServer listening on loopback.
+func newTCPClient(t testing.TB, opts ...Option) (*Memcached, *memServer) {
+	t.Helper()
+	srv := newMemServer()
+	m, err := NewMemcached("tcp", serveTCP(t, srv), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Close)
+	return m, srv
+}
+
+type logBuffer struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *logBuffer) Printf(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *logBuffer) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+func TestSetGetDelete(t *testing.T) {
+	m, _, _ := newTestClient(t)
+	if err := m.Set("key", "Hello World!\nEND\r\nBut no!\n\n", 10); err != nil {
+		t.Fatal(err)
+	}
+	value, err := m.Get("key")
+	if err != nil || value != "Hello World!\nEND\r\nBut no!\n\n" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+	if err := m.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	value, err = m.Get("key")
+	if err != nil || value != "" {
+		t.Fatalf("Get after Delete = %q, %v", value, err)
+	}
+	if err := m.Delete("key"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Delete of a missing key = %v, want ErrNotFound", err)
+	}
+}
+
+type countingTransport struct {
+	Transport
+	writes int
+}
+
+func (t *countingTransport) Write(data string) error {
+	t.writes++
+	return t.Transport.Write(data)
+}
+
+func TestNewMemcachedWithTransport(t *testing.T) {
+	srv := newMemServer()
+	tr := &countingTransport{Transport: newMockTransport(srv)}
+	m := NewMemcachedWithTransport(tr)
+	if err := m.Set("k", "in memory", 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := m.Get("k"); err != nil || value != "in memory" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+	if tr.writes == 0 {
+		t.Fatal("the client did not write through the supplied transport")
+	}
+	if it, found := srv.item("k"); !found || string(it.value) != "in memory" {
+		t.Fatal("the value did not reach the in-memory server")
+	}
+}
+
+func TestDeleteIfExists(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("k", "v", 0)
+	if err := m.DeleteIfExists("k"); err != nil {
+		t.Fatalf("DeleteIfExists of a present key = %v", err)
+	}
+	if err := m.DeleteIfExists("k"); err != nil {
+		t.Fatalf("DeleteIfExists of a missing key = %v", err)
+	}
+	if _, found := srv.item("k"); found {
+		t.Fatal("the key was not deleted")
+	}
+
+	tr.connectErrs = []error{errors.New("connection refused")}
+	tr.Close()
+	if err := m.DeleteIfExists("k"); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("DeleteIfExists on a failing transport = %v, want ErrUnreachable", err)
+	}
+	if err := m.DeleteIfExists("bad key"); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("DeleteIfExists of an invalid key = %v", err)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	m, srv, _ := newTestClient(t)
+	if err := m.Add("key", "first", 0); err != nil {
+		t.Fatalf("Add of an absent key = %v", err)
+	}
+	if err := m.Add("key", "second", 0); !errors.Is(err, ErrNotStored) {
+		t.Fatalf("Add of a present key = %v, want ErrNotStored", err)
+	}
+	if it, _ := srv.item("key"); string(it.value) != "first" {
+		t.Fatalf("value = %q, want the first one", it.value)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	created, err := m.Upsert("k", "first", 0)
+	if err != nil || !created {
+		t.Fatalf("Upsert of a new key = %v, %v, want created", created, err)
+	}
+	created, err = m.Upsert("k", "second", 0)
+	if err != nil || created {
+		t.Fatalf("Upsert of an existing key = %v, %v, want updated", created, err)
+	}
+	if it, _ := srv.item("k"); string(it.value) != "second" {
+		t.Fatalf("stored %q after the update", it.value)
+	}
+	want := "add k 0 0 5\r\nfirst\r\nadd k 0 0 6\r\nsecond\r\nset k 0 0 6\r\nsecond\r\n"
+	if got := tr.written.String(); got != want {
+		t.Fatalf("written %q, want %q", got, want)
+	}
+
+	if _, err := m.Upsert("bad key", "v", 0); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("Upsert of an invalid key = %v", err)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	tests := []struct {
+		name    string
+		present bool
+		wantErr error
+		want    string
+	}{
+		{name: "exists", present: true, want: "new"},
+		{name: "not exists", wantErr: ErrNotStored},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, srv, tr := newTestClient(t)
+			if tt.present {
+				srv.put("key", "old", 0)
+			}
+			err := m.Replace("key", "new", 5)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Replace = %v, want %v", err, tt.wantErr)
+			}
+			if got := tr.written.String(); got != "replace key 0 5 3\r\nnew\r\n" {
+				t.Fatalf("written %q", got)
+			}
+			it, found := srv.item("key")
+			if tt.want == "" && found {
+				t.Fatalf("key stored: %q", it.value)
+			}
+			if tt.want != "" && string(it.value) != tt.want {
+				t.Fatalf("value = %q, want %q", it.value, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendPrepend(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("log", "b", 0)
+	if err := m.Append("log", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Prepend("log", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if it, _ := srv.item("log"); string(it.value) != "abc" {
+		t.Fatalf("value = %q, want abc", it.value)
+	}
+	if got := tr.written.String(); got != "append log 0 0 1\r\nc\r\nprepend log 0 0 1\r\na\r\n" {
+		t.Fatalf("written %q", got)
+	}
+
+	if err := m.Append("missing", "x"); !errors.Is(err, ErrNotStored) {
+		t.Fatalf("Append to a missing key = %v, want ErrNotStored", err)
+	}
+	if err := m.Prepend("missing", "x"); !errors.Is(err, ErrNotStored) {
+		t.Fatalf("Prepend to a missing key = %v, want ErrNotStored", err)
+	}
+	if err := m.Append("bad key", "x"); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("Append with an invalid key = %v, want ErrInvalidArgument", err)
+	}
+}
+
+func TestIncrDecr(t *testing.T) {
+	m, srv, _ := newTestClient(t)
+	srv.put("n", "10", 0)
+	if v, err := m.Incr("n", 5); err != nil || v != 15 {
+		t.Fatalf("Incr = %d, %v, want 15", v, err)
+	}
+	if v, err := m.Decr("n", 3); err != nil || v != 12 {
+		t.Fatalf("Decr = %d, %v, want 12", v, err)
+	}
+	if v, err := m.Decr("n", 100); err != nil || v != 0 {
+		t.Fatalf("Decr below zero = %d, %v, want 0", v, err)
+	}
+	if _, err := m.Incr("missing", 1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Incr of a missing key = %v, want ErrNotFound", err)
+	}
+
+	srv.put("text", "abc", 0)
+	_, err := m.Incr("text", 1)
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Kind != "CLIENT_ERROR" {
+		t.Fatalf("Incr of a non-numeric value = %v, want a CLIENT_ERROR", err)
+	}
+	if v, err := m.Incr("n", 1); err != nil || v != 1 {
+		t.Fatalf("Incr after a CLIENT_ERROR = %d, %v", v, err)
+	}
+}
+
+func TestIncrUnparseableReply(t *testing.T) {
+	m := NewMemcachedWithTransport(newScriptTransport("twelve\r\n"))
+	if _, err := m.Incr("n", 1); err == nil {
+		t.Fatal("Incr accepted a non-numeric reply")
+	}
+}
+
+func TestTouch(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		wantErr error
+		anyErr  bool
+	}{
+		{name: "touched", reply: "TOUCHED\r\n"},
+		{name: "not found", reply: "NOT_FOUND\r\n", wantErr: ErrNotFound},
+		{name: "malformed", reply: "STORED\r\n", anyErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newScriptTransport(tt.reply)
+			err := NewMemcachedWithTransport(tr).Touch("key", 60)
+			if tt.anyErr {
+				if err == nil {
+					t.Fatal("Touch accepted a malformed reply")
+				}
+			} else if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("Touch = %v, want %v", err, tt.wantErr)
+			}
+			if got := tr.written.String(); got != "touch key 60\r\n" {
+				t.Fatalf("written %q", got)
+			}
+		})
+	}
+}
+
+func TestTouchValidates(t *testing.T) {
+	tr := newScriptTransport()
+	m := NewMemcachedWithTransport(tr)
+	if err := m.Touch("bad key", 1); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("Touch with an invalid key = %v", err)
+	}
+	if err := m.Touch("key", -1); err == nil {
+		t.Fatal("Touch accepted a negative ttl")
+	}
+	if tr.written.Len() != 0 {
+		t.Fatalf("invalid Touch was sent: %q", tr.written.String())
+	}
+}
+
+func TestGetAndTouch(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("session", "a\r\nb", 0)
+	value, err := m.GetAndTouch("session", 300)
+	if err != nil || value != "a\r\nb" {
+		t.Fatalf("GetAndTouch = %q, %v", value, err)
+	}
+	if got := tr.written.String(); got != "gat 300 session\r\n" {
+		t.Fatalf("written %q", got)
+	}
+	if it, _ := srv.item("session"); it.exp == 0 {
+		t.Fatal("ttl was not updated")
+	}
+
+	value, err = m.GetAndTouch("missing", 300)
+	if err != nil || value != "" {
+		t.Fatalf("GetAndTouch of a miss = %q, %v", value, err)
+	}
+	tr.written.Reset()
+	if _, err := m.GetAndTouch("session", -1); err == nil {
+		t.Fatal("GetAndTouch accepted a negative ttl")
+	}
+	if tr.written.Len() != 0 {
+		t.Fatalf("invalid GetAndTouch was sent: %q", tr.written.String())
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("a", "one\r\nEND\r\n", 0)
+	srv.put("c", "three", 0)
+	values, err := m.GetMulti([]Key{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[Key]string{"a": "one\r\nEND\r\n", "c": "three"}
+	if fmt.Sprint(values) != fmt.Sprint(want) {
+		t.Fatalf("GetMulti = %q, want %q", values, want)
+	}
+	if got := tr.written.String(); got != "get a b c\r\n" {
+		t.Fatalf("written %q, want a single get", got)
+	}
+
+	tr.written.Reset()
+	if _, err := m.GetMulti([]Key{"a", "bad key", "c"}); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("GetMulti with an invalid key = %v", err)
+	}
+	if tr.written.Len() != 0 {
+		t.Fatalf("GetMulti with an invalid key was sent: %q", tr.written.String())
+	}
+}
+
+func TestGetsCas(t *testing.T) {
+	m, srv, _ := newTestClient(t)
+	srv.put("counter", "1", 0)
+	value, casID, err := m.Gets("counter")
+	if err != nil || value != "1" || casID == 0 {
+		t.Fatalf("Gets = %q, %d, %v", value, casID, err)
+	}
+	if _, casID, err := m.Gets("missing"); err != nil || casID != 0 {
+		t.Fatalf("Gets of a miss = %d, %v", casID, err)
+	}
+
+	if err := m.Cas("counter", "2", 0, casID); err != nil {
+		t.Fatalf("Cas with the current id = %v", err)
+	}
+	if err := m.Cas("counter", "3", 0, casID); !errors.Is(err, ErrCASConflict) {
+		t.Fatalf("Cas with a stale id = %v, want ErrCASConflict", err)
+	}
+	if err := m.Cas("missing", "3", 0, casID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Cas of a missing key = %v, want ErrNotFound", err)
+	}
+	if it, _ := srv.item("counter"); string(it.value) != "2" {
+		t.Fatalf("value = %q, want 2", it.value)
+	}
+}
+
+func TestCasCommand(t *testing.T) {
+	tr := newScriptTransport("STORED\r\n")
+	if err := NewMemcachedWithTransport(tr).Cas("k", "v", 7, 42); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.written.String(); got != "cas k 0 7 1 42\r\nv\r\n" {
+		t.Fatalf("written %q", got)
+	}
+}
+
+func TestFlushAll(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("a", "1", 0)
+	if err := m.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := srv.item("a"); found {
+		t.Fatal("flush_all left the item")
+	}
+	if err := m.FlushAllDelay(10); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.written.String(); got != "flush_all\r\nflush_all 10\r\n" {
+		t.Fatalf("written %q", got)
+	}
+
+	unexpected := NewMemcachedWithTransport(newScriptTransport("STORED\r\n"))
+	if err := unexpected.FlushAll(); err == nil {
+		t.Fatal("FlushAll accepted an unexpected reply")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	version, err := NewMemcachedWithTransport(newScriptTransport("VERSION 1.6.21\r\n")).Version()
+	if err != nil || version != "1.6.21" {
+		t.Fatalf("Version = %q, %v", version, err)
+	}
+	if _, err := NewMemcachedWithTransport(newScriptTransport("OK\r\n")).Version(); err == nil {
+		t.Fatal("Version accepted a reply without VERSION")
+	}
+}
+
+func TestStats(t *testing.T) {
+	tr := newScriptTransport("STAT pid 1\r\nSTAT curr_items 3\r\nSTAT bytes 120\r\nSTAT version 1.6.21 (beta)\r\nEND\r\n")
+	stats, err := NewMemcachedWithTransport(tr).Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"pid": "1", "curr_items": "3", "bytes": "120", "version": "1.6.21 (beta)"}
+	if fmt.Sprint(stats) != fmt.Sprint(want) {
+		t.Fatalf("Stats = %q, want %q", stats, want)
+	}
+	if got := tr.written.String(); got != "stats\r\n" {
+		t.Fatalf("written %q", got)
+	}
+
+	bad := NewMemcachedWithTransport(newScriptTransport("STAT pid\r\nEND\r\n"))
+	if _, err := bad.Stats(); err == nil {
+		t.Fatal("Stats accepted a malformed STAT line")
+	}
+}
+
+func TestStatsSub(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("a", "1", 0)
+	items, err := m.StatsSub("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items["items:1:number"] != "1" || items["items:1:age"] != "10" {
+		t.Fatalf("StatsSub(items) = %q", items)
+	}
+	settings, err := m.StatsSub("settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings["item_size_max"] != "1048576" {
+		t.Fatalf("StatsSub(settings) = %q", settings)
+	}
+	if got := tr.written.String(); got != "stats items\r\nstats settings\r\n" {
+		t.Fatalf("written %q", got)
+	}
+
+	if _, err := m.StatsSub("bogus"); err == nil {
+		t.Fatal("StatsSub of an unknown section succeeded")
+	}
+	if _, err := m.StatsSub("items 1"); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("StatsSub with a space = %v, want ErrInvalidArgument", err)
+	}
+}
+
+func TestCommandMultiline(t *testing.T) {
+	tr := newScriptTransport("STAT a 1\r\nSTAT b 2\r\nSTAT c 3\r\nEND\r\n")
+	lines, err := NewMemcachedWithTransport(tr).commandMultiline("stats", "END")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{"STAT a 1", "STAT b 2", "STAT c 3"}) {
+		t.Fatalf("commandMultiline = %q", lines)
+	}
+
+	for _, reply := range []string{"ERROR\r\n", "CLIENT_ERROR bad\r\n", "STAT a 1\r\nSERVER_ERROR out of memory\r\n"} {
+		_, err := NewMemcachedWithTransport(newScriptTransport(reply)).commandMultiline("stats", "END")
+		if !errors.Is(err, ErrServerError) {
+			t.Errorf("commandMultiline with %q = %v, want ErrServerError", reply, err)
+		}
+	}
+}
+
+func TestNoReply(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("old", "1", 0)
+	if err := m.SetNoReply("k", "hello\r\nworld", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DeleteNoReply("old"); err != nil {
+		t.Fatal(err)
+	}
+	if tr.reads != 0 {
+		t.Fatalf("noreply commands issued %d reads", tr.reads)
+	}
+	want := "set k 0 0 12 noreply\r\nhello\r\nworld\r\ndelete old noreply\r\n"
+	if got := tr.written.String(); got != want {
+		t.Fatalf("written %q, want %q", got, want)
+	}
+	if _, found := srv.item("old"); found {
+		t.Fatal("DeleteNoReply did not delete")
+	}
+
+	value, err := m.Get("k")
+	if err != nil || value != "hello\r\nworld" {
+		t.Fatalf("Get after noreply = %q, %v", value, err)
+	}
+}
+
+func TestNoReplyErrorIsDiscarded(t *testing.T) {
+	tr := newScriptTransport("CLIENT_ERROR bad data chunk\r\n", "VERSION 1.6.21\r\n", "VALUE k 0 1\r\nv\r\nEND\r\n")
+	m := NewMemcachedWithTransport(tr)
+	if err := m.SetNoReply("k", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	value, err := m.Get("k")
+	if err != nil || value != "v" {
+		t.Fatalf("Get after a failed noreply = %q, %v", value, err)
+	}
+}
+
+func TestTimeoutOnSilentServer(t *testing.T) {
+	var accepted atomic.Int32
+	addr := serveSilent(t, &accepted)
+	m, err := NewMemcachedWithTimeout("tcp", addr, time.Second, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	for i := 1; i <= 2; i++ {
+		start := time.Now()
+		_, err := m.Get("k")
+		if !errors.Is(err, ErrTimeout) {
+			t.Fatalf("Get from a silent server = %v, want ErrTimeout", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("read deadline fired after %v", elapsed)
+		}
+		if got := accepted.Load(); got != int32(i) {
+			t.Fatalf("connections after %d timeouts = %d, want a redial each time", i, got)
+		}
+	}
+}
+
+func TestDialFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	m, _ := NewMemcachedWithTimeout("tcp", addr, 100*time.Millisecond, 100*time.Millisecond)
+	if err := m.Set("k", "v", 0); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Set to a closed port = %v, want ErrUnreachable", err)
+	}
+}
+
+func TestReconnectAfterFailedWrite(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	tr.flushErrs = []error{syscall.EPIPE}
+	if err := m.Set("k", "v", 0); err != nil {
+		t.Fatalf("Set after a failed write = %v", err)
+	}
+	if tr.connects != 2 {
+		t.Fatalf("connects = %d, want a reconnect", tr.connects)
+	}
+	if got := srv.received(); len(got) != 1 {
+		t.Fatalf("server received %q, want one set", got)
+	}
+}
+
+func TestReconnectIsBounded(t *testing.T) {
+	m, _, tr := newTestClient(t)
+	tr.flushErrs = []error{syscall.EPIPE, syscall.EPIPE, syscall.EPIPE}
+	if err := m.Set("k", "v", 0); !errors.Is(err, syscall.EPIPE) {
+		t.Fatalf("Set = %v, want the write error", err)
+	}
+	if tr.connects != 2 {
+		t.Fatalf("connects = %d, want one retry", tr.connects)
+	}
+}
+
+func TestReconnectAfterFailedRead(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("n", "1", 0)
+	srv.put("k", "v", 0)
+
+	tr.readErrs = []error{io.EOF}
+	if value, err := m.Get("k"); err != nil || value != "v" {
+		t.Fatalf("Get after a failed read = %q, %v", value, err)
+	}
+
+	tr.readErrs = []error{syscall.ECONNRESET}
+	if _, err := m.Incr("n", 1); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("Incr after a failed read = %v, want the read error", err)
+	}
+	if it, _ := srv.item("n"); string(it.value) != "2" {
+		t.Fatalf("counter = %q, want incr applied once", it.value)
+	}
+}
+
+func TestNoReconnectOnProtocolError(t *testing.T) {
+	tr := newScriptTransport("CLIENT_ERROR bad command line format\r\n", "STORED\r\n")
+	err := NewMemcachedWithTransport(tr).Set("k", "v", 0)
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) || serverErr.Kind != "CLIENT_ERROR" {
+		t.Fatalf("Set = %v, want the CLIENT_ERROR", err)
+	}
+	if tr.connects != 1 || strings.Count(tr.written.String(), "set ") != 1 {
+		t.Fatalf("CLIENT_ERROR was retried: %d connects, written %q", tr.connects, tr.written.String())
+	}
+}
+
+func TestProtocolErrorStages(t *testing.T) {
+	tests := []struct {
+		reply string
+		stage string
+	}{
+		{"VALUE k zero 1\r\n", "header"},
+		{"GARBAGE\r\n", "header"},
+		{"VALUE k 0 1\r\nvX\r\nEND\r\n", "terminator"},
+		{"VALUE k 0 1\r\nv\r\nSTORED\r\n", "header"},
+	}
+	for _, tt := range tests {
+		_, err := NewMemcachedWithTransport(newScriptTransport(tt.reply)).Get("k")
+		var protoErr *ProtocolError
+		if !errors.As(err, &protoErr) || protoErr.Stage != tt.stage {
+			t.Errorf("Get with %q = %v, want a ProtocolError at %s", tt.reply, err, tt.stage)
+		}
+	}
+
+	_, _, err := NewMemcachedWithTransport(newScriptTransport("VALUE k 0 1 nocas\r\n")).Gets("k")
+	var protoErr *ProtocolError
+	if !errors.As(err, &protoErr) || protoErr.Stage != "header" || protoErr.Line != "VALUE k 0 1 nocas\r\n" {
+		t.Fatalf("Gets with a garbage header = %#v", err)
+	}
+}
+
+func TestGetStrayReplyClosesConnection(t *testing.T) {
+	tr := newScriptTransport("STORED\r\nVALUE k 0 1\r\nv\r\nEND\r\n", "VALUE k 0 1\r\nv\r\nEND\r\n")
+	m := NewMemcachedWithTransport(tr)
+	_, err := m.Get("k")
+	var protoErr *ProtocolError
+	if !errors.As(err, &protoErr) || protoErr.Line != "STORED\r\n" {
+		t.Fatalf("Get after a stray STORED = %v, want a ProtocolError", err)
+	}
+	if tr.closes != 1 {
+		t.Fatalf("closes = %d, want the desynced connection closed", tr.closes)
+	}
+	if value, err := m.Get("k"); err != nil || value != "v" {
+		t.Fatalf("Get on a fresh connection = %q, %v", value, err)
+	}
+	if tr.connects != 2 {
+		t.Fatalf("connects = %d, want 2", tr.connects)
+	}
+}
+
+func TestBestEffort(t *testing.T) {
+	logger := &logBuffer{}
+	m, _, tr := newTestClient(t, WithBestEffort(true), WithLogger(logger))
+	tr.connectErrs = []error{errors.New("connection refused"), errors.New("connection refused"), errors.New("connection refused")}
+	if value, err := m.Get("k"); err != nil || value != "" {
+		t.Fatalf("best effort Get on a failing transport = %q, %v, want a miss", value, err)
+	}
+	if err := m.Set("k", "v", 0); err != nil {
+		t.Fatalf("best effort Set on a failing transport = %v", err)
+	}
+	if err := m.Delete("k"); err != nil {
+		t.Fatalf("best effort Delete on a failing transport = %v", err)
+	}
+	if !strings.Contains(strings.Join(logger.lines, "\n"), "ignored error") {
+		t.Fatalf("ignored errors were not logged: %q", logger.lines)
+	}
+	if _, err := m.Get("bad key"); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("best effort Get of an invalid key = %v", err)
+	}
+
+	m, _, tr = newTestClient(t, WithBestEffort(true))
+	for i := 0; i < 20; i++ {
+		tr.connectErrs = append(tr.connectErrs, errors.New("connection refused"))
+	}
+	writes := map[string]func() error{
+		"Add":          func() error { return m.Add("k", "v", 0) },
+		"SetWithFlags": func() error { return m.SetWithFlags("k", "v", 1<<8, 0) },
+		"SetObject":    func() error { return m.SetObject("k", map[string]int{"a": 1}, 0) },
+		"Append":       func() error { return m.Append("k", "v") },
+		"Touch":        func() error { return m.Touch("k", 60) },
+		"SetNoReply":   func() error { return m.SetNoReply("k", "v", 0) },
+		"Incr":         func() error { _, err := m.Incr("k", 1); return err },
+	}
+	for name, write := range writes {
+		if err := write(); err != nil {
+			t.Errorf("best effort %s on a failing transport = %v", name, err)
+		}
+	}
+	if values, err := m.GetMulti([]Key{"a", "b"}); err != nil || len(values) != 0 {
+		t.Fatalf("best effort GetMulti on a failing transport = %v, %v, want misses", values, err)
+	}
+	if _, found, err := m.GetOK("k"); err != nil || found {
+		t.Fatalf("best effort GetOK on a failing transport = %v, %v, want a miss", found, err)
+	}
+	if err := m.Add("k", "v", -1); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("best effort Add with a negative ttl = %v", err)
+	}
+
+	strict, _, tr := newTestClient(t)
+	tr.connectErrs = []error{errors.New("connection refused")}
+	if _, err := strict.Get("k"); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("strict Get on a failing transport = %v", err)
+	}
+}
+
+func TestLenient(t *testing.T) {
+	tr := newScriptTransport("VALUE k 0 1\nv\nEND\n", "STORED \n", "VALUE k 0 2\r\nv\n\r\nEND\r\n")
+	m := NewMemcachedWithTransport(tr, WithLenient(true))
+	if value, err := m.Get("k"); err != nil || value != "v" {
+		t.Fatalf("lenient Get with bare newlines = %q, %v", value, err)
+	}
+	if err := m.Set("k", "v", 0); err != nil {
+		t.Fatalf("lenient Set with trailing whitespace = %v", err)
+	}
+	if value, err := m.Get("k"); err != nil || value != "v\n" {
+		t.Fatalf("lenient Get of a value ending in a newline = %q, %v", value, err)
+	}
+
+	_, err := NewMemcachedWithTransport(newScriptTransport("VALUE k 0 1\nv\nEND\n")).Get("k")
+	var protoErr *ProtocolError
+	if !errors.As(err, &protoErr) {
+		t.Fatalf("strict Get with bare newlines = %v, want a ProtocolError", err)
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	m, _, _ := newTestClient(t)
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				key := Key(fmt.Sprintf("k%d", g))
+				want := fmt.Sprintf("value %d-%d", g, i)
+				if err := m.Set(key, want, 0); err != nil {
+					errs <- err
+					return
+				}
+				got, err := m.Get(key)
+				if err != nil || got != want {
+					errs <- fmt.Errorf("Get(%s) = %q, %v, want %q", key, got, err, want)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestGetAssemblesChunkedBody(t *testing.T) {
+	m, srv := newTCPClient(t)
+	value := strings.Repeat("0123456789\r\n", 1000)
+	srv.put("big", value, 0)
+	srv.put("small", "x", 0)
+	srv.chunk = 997
+
+	for i := 0; i < 2; i++ {
+		got, err := m.Get("big")
+		if err != nil || got != value {
+			t.Fatalf("Get of a chunked body = %d bytes, %v, want %d bytes", len(got), err, len(value))
+		}
+	}
+	if got, err := m.Get("small"); err != nil || got != "x" {
+		t.Fatalf("Get after chunked bodies = %q, %v", got, err)
+	}
+}
+
+func TestTransportSocketReadLineReadN(t *testing.T) {
+	srv := newMemServer()
+	srv.put("k", "hello\r\nworld", 0)
+	srv.chunk = 3
+	tr := NewTransportSocket("tcp", serveTCP(t, srv))
+	if err := tr.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	if err := tr.Write("get k\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := tr.ReadLine(); err != nil || line != "VALUE k 0 12\r\n" {
+		t.Fatalf("ReadLine = %q, %v", line, err)
+	}
+	if body, err := tr.ReadN(14); err != nil || string(body) != "hello\r\nworld\r\n" {
+		t.Fatalf("ReadN across chunks = %q, %v", body, err)
+	}
+	if line, err := tr.ReadLine(); err != nil || line != "END\r\n" {
+		t.Fatalf("ReadLine after ReadN = %q, %v", line, err)
+	}
+}
+
+func TestBufferedWriteFlushedBeforeRead(t *testing.T) {
+	srv := newMemServer()
+	tr := NewTransportSocketBuffered("tcp", serveTCP(t, srv), 4096)
+	if err := tr.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	if err := tr.Write("version\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if tr.writer.Buffered() == 0 {
+		t.Fatal("Write went straight to the socket")
+	}
+
+	if err := tr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := tr.ReadLine(); err != nil || !strings.HasPrefix(line, "VERSION ") {
+		t.Fatalf("ReadLine after Flush = %q, %v", line, err)
+	}
+
+	mc, _, mt := newTestClient(t)
+	_ = mc.Set("k", "v", 0)
+	_, _ = mc.Get("k")
+	_, _ = mc.GetMulti([]Key{"k", "other"})
+	_ = mc.SetNoReply("n", "v", 0)
+	_, _ = mc.Incr("n", 1)
+	if mt.stalled != 0 {
+		t.Fatalf("%d reads were attempted before the writes were flushed", mt.stalled)
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	var allocs atomic.Int32
+	pool := &sync.Pool{New: func() interface{} {
+		allocs.Add(1)
+		return new([]byte)
+	}}
+	m, srv := newTCPClient(t, WithBufferPool(pool))
+	large := strings.Repeat("L", 8192)
+	srv.put("large", large, 0)
+	srv.put("small", "s\x00\r\n", 0)
+
+	first, err := m.Get("large")
+	if err != nil || first != large {
+		t.Fatalf("pooled Get = %d bytes, %v", len(first), err)
+	}
+	if allocs.Load() == 0 {
+		t.Fatal("Get did not take its buffer from the pool")
+	}
+	for i := 0; i < 3; i++ {
+		if value, err := m.Get("small"); err != nil || value != "s\x00\r\n" {
+			t.Fatalf("pooled Get of a smaller value = %q, %v", value, err)
+		}
+		if value, err := m.Get("large"); err != nil || value != large {
+			t.Fatalf("pooled Get of the larger value = %d bytes, %v", len(value), err)
+		}
+	}
+	if first != large {
+		t.Fatal("a returned value changed when its buffer was reused")
+	}
+
+	streamPool := &sync.Pool{New: func() interface{} {
+		t.Error("GetStream used the buffer pool")
+		return new([]byte)
+	}}
+	sm, ssrv := newTCPClient(t, WithBufferPool(streamPool))
+	ssrv.put("large", large, 0)
+	r, err := sm.GetStream("large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(r); err != nil || string(data) != large {
+		t.Fatalf("GetStream = %d bytes, %v", len(data), err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	for _, pooled := range []bool{false, true} {
+		name := "alloc"
+		var opts []Option
+		if pooled {
+			name = "pool"
+			opts = append(opts, WithBufferPool(&sync.Pool{New: func() interface{} { return new([]byte) }}))
+		}
+		b.Run(name, func(b *testing.B) {
+			m, srv := newTCPClient(b, opts...)
+			srv.put("k", strings.Repeat("v", 16*1024), 0)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := m.Get("k"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestTTLValidate(t *testing.T) {
+	tests := []struct {
+		ttl     TTL
+		wantErr bool
+	}{
+		{0, false},
+		{maxRelativeTTL, false},
+		{maxRelativeTTL + 1, false},
+		{-1, true},
+	}
+	for _, tt := range tests {
+		if err := tt.ttl.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("TTL(%d).Validate() = %v, want error %v", tt.ttl, err, tt.wantErr)
+		}
+	}
+
+	m, _, tr := newTestClient(t)
+	if err := m.Set("k", "v", -1); err == nil {
+		t.Fatal("Set accepted a negative ttl")
+	}
+	if err := m.Touch("k", -1); err == nil {
+		t.Fatal("Touch accepted a negative ttl")
+	}
+	if tr.written.Len() != 0 {
+		t.Fatalf("commands with a negative ttl were sent: %q", tr.written.String())
+	}
+}
+
+func TestKeyValidate(t *testing.T) {
+	tests := []struct {
+		key     Key
+		wantErr bool
+	}{
+		{"user:1", false},
+		{Key(strings.Repeat("k", defaultMaxKeyLength)), false},
+		{"", true},
+		{Key(strings.Repeat("k", defaultMaxKeyLength+1)), true},
+		{"with space", true},
+		{"new\nline", true},
+		{"del\x7f", true},
+	}
+	for _, tt := range tests {
+		err := tt.key.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Key(%q).Validate() = %v, want error %v", tt.key, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("Key(%q).Validate() = %v, want ErrInvalidArgument", tt.key, err)
+		}
+	}
+
+	m, _, _ := newTestClient(t, WithPrefix("app:"), WithMaxKeyLength(10))
+	if err := m.ValidateKey("123456"); err != nil {
+		t.Fatalf("ValidateKey within the limit = %v", err)
+	}
+	if err := m.ValidateKey("1234567"); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("ValidateKey over the limit with the prefix = %v", err)
+	}
+}
+
+func TestTTLFromDuration(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name string
+		d    time.Duration
+		want TTL
+	}{
+		{"zero", 0, 0},
+		{"ten seconds", 10 * time.Second, 10},
+		{"fraction rounds up", 1500 * time.Millisecond, 2},
+		{"thirty days", 30 * 24 * time.Hour, maxRelativeTTL},
+		{"forty days", 40 * 24 * time.Hour, TTL(now.Add(40 * 24 * time.Hour).Unix())},
+		{"negative", -time.Second, TTL(now.Unix() - 1)},
+	}
+	for _, tt := range tests {
+		if got := ttlFromDuration(tt.d, now); got != tt.want {
+			t.Errorf("%s: ttlFromDuration(%v) = %d, want %d", tt.name, tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestTTLFromTime(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	if got := ttlFromTime(now.Add(time.Hour), now); got != 3600 {
+		t.Fatalf("ttlFromTime in an hour = %d, want 3600", got)
+	}
+	future := now.Add(60 * 24 * time.Hour)
+	if got := ttlFromTime(future, now); got != TTL(future.Unix()) {
+		t.Fatalf("ttlFromTime in 60 days = %d, want the timestamp", got)
+	}
+	if got := ttlFromTime(now.Add(-time.Hour), now); got <= maxRelativeTTL {
+		t.Fatalf("ttlFromTime in the past = %d, want a past timestamp", got)
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	m, _, _ := newTestClient(t, WithClock(func() time.Time { return now }))
+	if got := m.TTLFromDuration(40 * 24 * time.Hour); got != 1703456000 {
+		t.Fatalf("TTLFromDuration(40 days) = %d, want 1703456000", got)
+	}
+	if got := m.TTLFromTime(now.Add(time.Minute)); got != 60 {
+		t.Fatalf("TTLFromTime in a minute = %d, want 60", got)
+	}
+}
+
+func TestSetWithDuration(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	m, srv, tr := newTestClient(t, WithClock(func() time.Time { return now }))
+	srv.now = func() time.Time { return now }
+	if err := m.SetWithDuration("k", "v", 40*24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("set k 0 %d 1\r\nv\r\n", now.Add(40*24*time.Hour).Unix())
+	if got := tr.written.String(); got != want {
+		t.Fatalf("written %q, want %q", got, want)
+	}
+
+	if err := m.SetWithDuration("gone", "v", -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := m.GetOK("gone"); found {
+		t.Fatal("a negative duration stored an item that does not expire")
+	}
+}
+
+func TestSetDefault(t *testing.T) {
+	m, _, tr := newTestClient(t, WithDefaultTTL(300))
+	if err := m.SetDefault("k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.written.String(); got != "set k 0 300 1\r\nv\r\n" {
+		t.Fatalf("written %q", got)
+	}
+
+	m, _, tr = newTestClient(t)
+	if err := m.SetDefault("k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.written.String(); got != "set k 0 0 1\r\nv\r\n" {
+		t.Fatalf("written %q without a default ttl", got)
+	}
+}
+
+func TestSetWithJitter(t *testing.T) {
+	m, _, tr := newTestClient(t)
+	seen := make(map[int]bool)
+	for i := 0; i < 50; i++ {
+		tr.written.Reset()
+		if err := m.SetWithJitter("k", "v", 100, 10); err != nil {
+			t.Fatal(err)
+		}
+		var ttl int
+		if _, err := fmt.Sscanf(tr.written.String(), "set k 0 %d 1", &ttl); err != nil {
+			t.Fatal(err)
+		}
+		if ttl < 100 || ttl > 110 {
+			t.Fatalf("jittered ttl = %d, want [100, 110]", ttl)
+		}
+		seen[ttl] = true
+	}
+	if len(seen) < 2 {
+		t.Fatalf("50 calls all used ttl %v", seen)
+	}
+
+	now := time.Unix(1700000000, 0)
+	m, _, tr = newTestClient(t, WithClock(func() time.Time { return now }), WithRandom(func(n int64) int64 { return n - 1 }))
+	if err := m.SetWithJitter("k", "v", maxRelativeTTL, 60); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("set k 0 %d 1\r\nv\r\n", now.Unix()+int64(maxRelativeTTL)+60)
+	if got := tr.written.String(); got != want {
+		t.Fatalf("written %q, want the jitter past 30 days as a timestamp %q", got, want)
+	}
+
+	tr.written.Reset()
+	if err := m.SetWithJitter("k", "v", 0, 60); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.written.String(); got != "set k 0 0 1\r\nv\r\n" {
+		t.Fatalf("written %q, want a base of 0 to stay without expiry", got)
+	}
+	if err := m.SetWithJitter("k", "v", 100, -1); err == nil {
+		t.Fatal("SetWithJitter accepted a negative jitter")
+	}
+}
+
+func TestBytesRoundTrip(t *testing.T) {
+	m, _, _ := newTestClient(t, WithCompression(64))
+	small := []byte{0x00, 0xff, '\r', '\n', 0x00, 'E', 'N', 'D', '\r', '\n', 0x80}
+	large := bytes.Repeat(small, 100)
+	for _, value := range [][]byte{small, large, {}} {
+		if err := m.SetBytes("k", value, 0); err != nil {
+			t.Fatal(err)
+		}
+		got, found, err := m.GetBytes("k")
+		if err != nil || !found || !bytes.Equal(got, value) {
+			t.Fatalf("GetBytes = %q, %v, %v, want %q", got, found, err, value)
+		}
+	}
+
+	value := []byte{1, 2, 3}
+	_ = m.SetBytes("k", value, 0)
+	value[0] = 9
+	got, _, _ := m.GetBytes("k")
+	if got[0] != 1 {
+		t.Fatal("SetBytes kept a reference to the caller's slice")
+	}
+	got[0] = 7
+	if again, _, _ := m.GetBytes("k"); again[0] != 1 {
+		t.Fatal("GetBytes returned a shared slice")
+	}
+	if got, found, err := m.GetBytes("missing"); err != nil || found || got != nil {
+		t.Fatalf("GetBytes of a miss = %q, %v, %v", got, found, err)
+	}
+}
+
+func TestFlagsRoundTrip(t *testing.T) {
+	m, srv, _ := newTestClient(t)
+	for _, flags := range []uint32{0, 1 << 8, 0xdead0000} {
+		if err := m.SetWithFlags("k", "v", flags, 0); err != nil {
+			t.Fatal(err)
+		}
+		if it, _ := srv.item("k"); it.flags != flags {
+			t.Fatalf("stored flags = %d, want %d", it.flags, flags)
+		}
+		value, got, err := m.GetWithFlags("k")
+		if err != nil || value != "v" || got != flags {
+			t.Fatalf("GetWithFlags = %q, %d, %v, want flags %d", value, got, err, flags)
+		}
+	}
+	if _, flags, err := m.GetWithFlags("missing"); err != nil || flags != 0 {
+		t.Fatalf("GetWithFlags of a miss = %d, %v", flags, err)
+	}
+	if err := m.SetWithFlags("k", "v", FlagCompressed, 0); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("SetWithFlags with a reserved bit = %v, want ErrInvalidArgument", err)
+	}
+}
+
+func TestSetWithFlagsRejectsReservedBits(t *testing.T) {
+	m, _, tr := newTestClient(t)
+	for _, flags := range []uint32{FlagCompressed, FlagJSON, FlagGob, 1<<8 | FlagCompressed} {
+		if err := m.SetWithFlags("k", "v", flags, 0); !errors.Is(err, ErrInvalidArgument) {
+			t.Fatalf("SetWithFlags(%#x) = %v, want ErrInvalidArgument", flags, err)
+		}
+	}
+	if tr.written.Len() != 0 {
+		t.Fatalf("flags overlapping ReservedFlagMask were sent: %q", tr.written.String())
+	}
+	if ReservedFlagMask&(1<<8) != 0 {
+		t.Fatal("ReservedFlagMask covers user bits")
+	}
+}
+
+func TestPing(t *testing.T) {
+	tr := newScriptTransport("VERSION 1.6.0\r\n")
+	if err := NewMemcachedWithTransport(tr).Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if tr.connects != 1 || tr.written.String() != "version\r\n" {
+		t.Fatalf("Ping: %d connects, written %q", tr.connects, tr.written.String())
+	}
+
+	failing := NewMemcachedWithTransport(newScriptTransport("SERVER_ERROR out of memory\r\n"))
+	if err := failing.Ping(); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Ping of a failing server = %v, want ErrUnreachable", err)
+	}
+
+	down := newScriptTransport()
+	down.connectErrs = []error{syscall.ECONNREFUSED, syscall.ECONNREFUSED}
+	if err := NewMemcachedWithTransport(down).Ping(); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Ping of a dead socket = %v, want ErrUnreachable", err)
+	}
+}
+
+func TestQuit(t *testing.T) {
+	m, _, tr := newTestClient(t)
+	if err := m.Set("k", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	tr.written.Reset()
+	reads := tr.reads
+	if err := m.Quit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.written.String(); got != "quit\r\n" {
+		t.Fatalf("written %q, want quit", got)
+	}
+	if tr.connected || tr.closes != 1 {
+		t.Fatalf("transport not closed after quit: connected %v, %d closes", tr.connected, tr.closes)
+	}
+	if tr.reads != reads {
+		t.Fatalf("Quit read a reply: %d reads", tr.reads-reads)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	m, srv, tr := newTestClient(t, WithPrefix("svc:"))
+	if err := m.Set("a", "1", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := srv.item("svc:a"); !found {
+		t.Fatal("Set did not prefix the key")
+	}
+	srv.put("svc:b", "2", 0)
+	values, err := m.GetMulti([]Key{"a", "b"})
+	if err != nil || values["a"] != "1" || values["b"] != "2" {
+		t.Fatalf("GetMulti = %q, %v, want the prefix stripped", values, err)
+	}
+	if err := m.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	want := "set svc:a 0 0 1\r\n1\r\nget svc:a svc:b\r\ndelete svc:a\r\n"
+	if got := tr.written.String(); got != want {
+		t.Fatalf("written %q, want %q", got, want)
+	}
+
+	long, _, _ := newTestClient(t, WithPrefix(strings.Repeat("p", 245)))
+	if err := long.Set("abcdef", "v", 0); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("Set with a prefixed key over 250 bytes = %v, want ErrInvalidArgument", err)
+	}
+	if err := long.Set("abcde", "v", 0); err != nil {
+		t.Fatalf("Set with a prefixed key of 250 bytes = %v", err)
+	}
+	spaced, _, _ := newTestClient(t, WithPrefix("bad prefix:"))
+	if err := spaced.Set("k", "v", 0); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("Set with a prefix containing a space = %v, want ErrInvalidArgument", err)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		call  func(m *Memcached) error
+		want  error
+	}{
+		{"set not stored", "NOT_STORED\r\n", func(m *Memcached) error { return m.Set("k", "v", 0) }, ErrNotStored},
+		{"add exists", "NOT_STORED\r\n", func(m *Memcached) error { return m.Add("k", "v", 0) }, ErrNotStored},
+		{"delete miss", "NOT_FOUND\r\n", func(m *Memcached) error { return m.Delete("k") }, ErrNotFound},
+		{"cas conflict", "EXISTS\r\n", func(m *Memcached) error { return m.Cas("k", "v", 0, 1) }, ErrCASConflict},
+		{"cas miss", "NOT_FOUND\r\n", func(m *Memcached) error { return m.Cas("k", "v", 0, 1) }, ErrNotFound},
+		{"touch miss", "NOT_FOUND\r\n", func(m *Memcached) error { return m.Touch("k", 10) }, ErrNotFound},
+		{"unknown command", "ERROR\r\n", func(m *Memcached) error { return m.Set("k", "v", 0) }, ErrServerError},
+		{"server error", "SERVER_ERROR out of memory storing object\r\n", func(m *Memcached) error { return m.Set("k", "v", 0) }, ErrServerError},
+		{"client error", "CLIENT_ERROR bad data chunk\r\n", func(m *Memcached) error { return m.Delete("k") }, ErrServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call(NewMemcachedWithTransport(newScriptTransport(tt.reply)))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	value, err := NewMemcachedWithTransport(newScriptTransport("END\r\n")).Get("k")
+	if value != "" || err != nil {
+		t.Fatalf("Get of a miss = %q, %v, want no error", value, err)
+	}
+}
+
+func TestSmallReadBuffer(t *testing.T) {
+	srv := newMemServer()
+	m, err := NewMemcachedBuffered("tcp", serveTCP(t, srv), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	value := strings.Repeat("abcdefghij", 10000)
+	if err := m.Set("big", value, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if got, err := m.Get("big"); err != nil || got != value {
+			t.Fatalf("Get with a 16 byte buffer = %d bytes, %v", len(got), err)
+		}
+	}
+	if got := NewTransportSocket("tcp", "127.0.0.1:11211").bufSize; got != 0 {
+		t.Fatalf("default transport buffer = %d, want the bufio default", got)
+	}
+}
+
+func TestMaxValueSize(t *testing.T) {
+	m, _, tr := newTestClient(t)
+	if err := m.Set("k", strings.Repeat("v", defaultMaxValueSize), 0); err != nil {
+		t.Fatalf("Set at the default limit = %v", err)
+	}
+	tr.written.Reset()
+	if err := m.Set("k", strings.Repeat("v", defaultMaxValueSize+1), 0); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("Set over the default limit = %v, want ErrValueTooLarge", err)
+	}
+	if err := m.Add("k", strings.Repeat("v", defaultMaxValueSize+1), 0); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("Add over the default limit = %v, want ErrValueTooLarge", err)
+	}
+	if tr.written.Len() != 0 {
+		t.Fatalf("an oversized value was sent: %d bytes", tr.written.Len())
+	}
+
+	raised, _, _ := newTestClient(t, WithMaxValueSize(2*defaultMaxValueSize))
+	if err := raised.Set("k", strings.Repeat("v", 2*defaultMaxValueSize), 0); err != nil {
+		t.Fatalf("Set at a raised limit = %v", err)
+	}
+	if err := raised.Set("k", strings.Repeat("v", 2*defaultMaxValueSize+1), 0); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("Set over a raised limit = %v, want ErrValueTooLarge", err)
+	}
+}
+
+func TestServerErrorParsing(t *testing.T) {
+	tests := []struct {
+		reply   string
+		kind    string
+		message string
+	}{
+		{"CLIENT_ERROR bad data chunk\r\n", "CLIENT_ERROR", "bad data chunk"},
+		{"SERVER_ERROR object too large for cache\r\n", "SERVER_ERROR", "object too large for cache"},
+	}
+	for _, tt := range tests {
+		err := NewMemcachedWithTransport(newScriptTransport(tt.reply)).Set("k", "v", 0)
+		var serverErr *ServerError
+		if !errors.As(err, &serverErr) {
+			t.Fatalf("Set with %q = %v, want a ServerError", tt.reply, err)
+		}
+		if serverErr.Kind != tt.kind || serverErr.Message != tt.message {
+			t.Fatalf("ServerError = %+v, want %s %q", serverErr, tt.kind, tt.message)
+		}
+		if want := fmt.Sprintf("error: %q\n", tt.reply); err.Error() != want {
+			t.Fatalf("Error() = %q, want %q", err.Error(), want)
+		}
+	}
+}
+
+func TestGetMultiWithFlags(t *testing.T) {
+	m, srv, _ := newTestClient(t)
+	srv.put("a", "1", 1<<8)
+	srv.put("b", "2", 1<<9)
+	items, err := m.GetMultiWithFlags([]Key{"a", "b", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("GetMultiWithFlags = %+v, want two hits", items)
+	}
+	if items["a"].Value != "1" || items["a"].Flags != 1<<8 || items["b"].Value != "2" || items["b"].Flags != 1<<9 {
+		t.Fatalf("GetMultiWithFlags = %+v", items)
+	}
+	if _, found := items["missing"]; found {
+		t.Fatal("a miss is in the map")
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memcached.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets are unavailable: %v", err)
+	}
+	serveListener(t, ln, newMemServer())
+	m, err := NewMemcachedUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err := m.Set("k", "over a\r\nunix socket", 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := m.Get("k"); err != nil || value != "over a\r\nunix socket" {
+		t.Fatalf("Get over a unix socket = %q, %v", value, err)
+	}
+	if err := m.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnStats(t *testing.T) {
+	srv := newMemServer()
+	var transports []*mockTransport
+	m := newMemcached("", 2, func() Transport {
+		tr := newMockTransport(srv)
+		transports = append(transports, tr)
+		return tr
+	}, nil)
+	_ = m.Set("k", "v", 0)
+	_, _ = m.Get("k")
+	_, _ = m.Get("missing")
+	if got := m.ConnStats(); got.Commands != 3 || got.Errors != 0 || got.Reconnects != 0 {
+		t.Fatalf("ConnStats after three commands = %+v", got)
+	}
+
+	transports[0].flushErrs = []error{io.ErrClosedPipe}
+	if err := m.Set("k", "v", 0); err == nil {
+		t.Fatal("Set on a broken connection succeeded")
+	}
+	if _, err := m.Get("k"); err != nil {
+		t.Fatal(err)
+	}
+	if len(transports) != 2 {
+		t.Fatalf("%d transports, want the broken one replaced", len(transports))
+	}
+	if got := m.ConnStats(); got.Commands != 5 || got.Errors != 1 || got.Reconnects != 1 {
+		t.Fatalf("ConnStats after a replaced connection = %+v, want 5 commands, 1 error, 1 reconnect", got)
+	}
+
+	tm, _ := newTCPClient(t)
+	_ = tm.Set("k", "v", 0)
+	_, _ = tm.Get("k")
+	written := len("set k 0 0 1\r\nv\r\n") + len("get k\r\n")
+	read := len("STORED\r\n") + len("VALUE k 0 1\r\nv\r\nEND\r\n")
+	if got := tm.ConnStats(); got.BytesWritten != uint64(written) || got.BytesRead != uint64(read) {
+		t.Fatalf("ConnStats bytes = %d written, %d read, want %d and %d", got.BytesWritten, got.BytesRead, written, read)
+	}
+}
+
+func TestReconnectMethod(t *testing.T) {
+	m, _, tr := newTestClient(t)
+	if err := m.Set("k", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if tr.closes != 1 || tr.connects != 2 {
+		t.Fatalf("Reconnect: %d closes, %d connects, want the old connection closed and a new one", tr.closes, tr.connects)
+	}
+	if value, err := m.Get("k"); err != nil || value != "v" || tr.connects != 2 {
+		t.Fatalf("Get after Reconnect = %q, %v, %d connects", value, err, tr.connects)
+	}
+
+	tr.connectErrs = []error{errors.New("connection refused")}
+	if err := m.Reconnect(); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Reconnect with a failing dial = %v, want ErrUnreachable", err)
+	}
+}
+
+func TestReconnectConcurrent(t *testing.T) {
+	m, srv := newTCPClient(t)
+	srv.put("k", "v", 0)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				if value, err := m.Get("k"); err != nil || value != "v" {
+					t.Errorf("Get during Reconnect = %q, %v", value, err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		if err := m.Reconnect(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if got := srv.conns.Load(); got < 2 {
+		t.Fatalf("connections = %d, want Reconnect to dial fresh ones", got)
+	}
+}
+
+func TestConnStatsGrowingPool(t *testing.T) {
+	srv := newMemServer()
+	srv.delay = 2 * time.Millisecond
+	m, err := NewMemcachedPool("tcp", serveTCP(t, srv), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				_ = m.Set(Key(fmt.Sprintf("k%d", g)), "v", 0)
+			}
+		}(g)
+	}
+	wg.Wait()
+	if got := srv.conns.Load(); got < 2 {
+		t.Fatalf("connections = %d, want the pool to grow", got)
+	}
+	if got := m.ConnStats(); got.Reconnects != 0 || got.Errors != 0 {
+		t.Fatalf("ConnStats of a growing pool = %+v, want no reconnects", got)
+	}
+
+	single, _, tr := newTestClient(t)
+	_ = single.Set("k", "v", 0)
+	tr.readErrs = []error{io.ErrUnexpectedEOF}
+	_, _ = single.Get("k")
+	_, _ = single.Get("k")
+	if got := single.ConnStats().Reconnects; got != 1 || tr.connects != 2 {
+		t.Fatalf("Reconnects = %d after %d dials of one transport, want 1", got, tr.connects)
+	}
+}
+
+func TestIdleCheckReconnects(t *testing.T) {
+	m, srv, tr := newTestClient(t, WithIdleCheck(10*time.Millisecond))
+	if err := m.Set("k", "1", 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	tr.readErrs = []error{io.EOF}
+	if err := m.Set("k", "2", 0); err != nil {
+		t.Fatalf("Set on a connection closed while idle = %v", err)
+	}
+	if tr.connects != 2 {
+		t.Fatalf("connects = %d, want a reconnect after the probe", tr.connects)
+	}
+	if got := srv.received(); fmt.Sprint(got) != "[set k 0 0 1 version set k 0 0 1]" {
+		t.Fatalf("server received %q", got)
+	}
+
+	tr.written.Reset()
+	if err := m.Set("k", "3", 0); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(tr.written.String(), "version") {
+		t.Fatal("a connection reused without idling was probed")
+	}
+}
+
+func TestGetOK(t *testing.T) {
+	m, srv, _ := newTestClient(t)
+	srv.put("empty", "", 0)
+	value, found, err := m.GetOK("empty")
+	if err != nil || !found || value != "" {
+		t.Fatalf("GetOK of an empty value = %q, %v, %v", value, found, err)
+	}
+	value, found, err = m.GetOK("missing")
+	if err != nil || found || value != "" {
+		t.Fatalf("GetOK of a miss = %q, %v, %v", value, found, err)
+	}
+	if _, _, err := m.GetOK(""); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("GetOK with an empty key = %v", err)
+	}
+}
+
+func TestGetMultiFunc(t *testing.T) {
+	m, srv, _ := newTestClient(t)
+	srv.put("hit", "cached", 0)
+	var loaded []Key
+	values, err := m.GetMultiFunc([]Key{"hit", "miss", "absent", "miss"}, func(key Key) (string, TTL, bool) {
+		loaded = append(loaded, key)
+		if key == "absent" {
+			return "", 0, false
+		}
+		return "loaded", 60, true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[Key]string{"hit": "cached", "miss": "loaded"}
+	if !reflect.DeepEqual(values, want) {
+		t.Fatalf("GetMultiFunc = %v, want %v", values, want)
+	}
+	if !reflect.DeepEqual(loaded, []Key{"miss", "absent"}) {
+		t.Fatalf("onMiss called for %v", loaded)
+	}
+	if it, found := srv.item("miss"); !found || string(it.value) != "loaded" {
+		t.Fatal("a loaded miss was not written back")
+	}
+	if _, found := srv.item("absent"); found {
+		t.Fatal("a miss onMiss declined was stored")
+	}
+}
+
+func TestGetMultiOrdered(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("a", "1", 0)
+	srv.put("c", "", 0)
+	results, err := m.GetMultiOrdered([]Key{"a", "missing", "c", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Result{
+		{Key: "a", Value: "1", Found: true},
+		{Key: "missing"},
+		{Key: "c", Value: "", Found: true},
+		{Key: "a", Value: "1", Found: true},
+	}
+	if fmt.Sprint(results) != fmt.Sprint(want) {
+		t.Fatalf("GetMultiOrdered = %+v, want %+v", results, want)
+	}
+	if got := tr.written.String(); strings.Count(got, "get ") != 1 {
+		t.Fatalf("written %q, want a single get", got)
+	}
+}
+
+func TestCRLFInjection(t *testing.T) {
+	m, _, tr := newTestClient(t)
+	evil := Key("evil 0 0 0\r\nget secret")
+	calls := map[string]func() error{
+		"Set":        func() error { return m.Set(evil, "v", 0) },
+		"Get":        func() error { _, err := m.Get(evil); return err },
+		"Delete":     func() error { return m.Delete(evil) },
+		"Touch":      func() error { return m.Touch(evil, 1) },
+		"Incr":       func() error { _, err := m.Incr(evil, 1); return err },
+		"GetMulti":   func() error { _, err := m.GetMulti([]Key{"ok", evil}); return err },
+		"SetNoReply": func() error { return m.SetNoReply(evil, "v", 0) },
+		"MetaGet":    func() error { _, _, err := m.MetaGet("k", "v\r\nget secret"); return err },
+		"MetaSet":    func() error { _, err := m.MetaSet("k", "v", "T0\r\nget secret"); return err },
+		"StatsSub":   func() error { _, err := m.StatsSub("items\r\nget secret"); return err },
+		"Raw":        func() error { _, err := m.RawCommand("version\r\nget secret"); return err },
+	}
+	for name, call := range calls {
+		if err := call(); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%s with an injected command = %v, want ErrInvalidArgument", name, err)
+		}
+	}
+	if tr.written.Len() != 0 {
+		t.Fatalf("injected commands were sent: %q", tr.written.String())
+	}
+
+	if err := m.Set("k", "set evil 0 0 0\r\nget secret", 0); err != nil {
+		t.Fatalf("Set of a value containing CRLF = %v", err)
+	}
+	if value, err := m.Get("k"); err != nil || value != "set evil 0 0 0\r\nget secret" {
+		t.Fatalf("Get = %q, %v, want the body kept intact", value, err)
+	}
+}
+
+func TestNewMemcachedConnected(t *testing.T) {
+	srv := newMemServer()
+	m, err := NewMemcachedConnected("tcp", serveTCP(t, srv))
+	if err != nil {
+		t.Fatalf("NewMemcachedConnected to a live server = %v", err)
+	}
+	defer m.Close()
+	if got := srv.conns.Load(); got != 1 {
+		t.Fatalf("connections after construction = %d, want an eager connect", got)
+	}
+	if err := m.Set("k", "v", 0); err != nil || srv.conns.Load() != 1 {
+		t.Fatalf("Set = %v, %d connections, want the eager connection reused", err, srv.conns.Load())
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	if _, err := NewMemcachedConnected("tcp", addr); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("NewMemcachedConnected to a closed port = %v, want ErrUnreachable", err)
+	}
+	lazy, err := NewMemcached("tcp", addr)
+	if err != nil {
+		t.Fatalf("NewMemcached to a closed port = %v, want a lazy connect", err)
+	}
+	lazy.Close()
+}
+
+func TestMaintenanceStatus(t *testing.T) {
+	m, _, tr := newTestClient(t)
+	crawler, err := m.LRUCrawlerStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"lru_crawler": "yes", "lru_crawler_running": "0"}; fmt.Sprint(crawler) != fmt.Sprint(want) {
+		t.Fatalf("LRUCrawlerStatus = %q, want %q", crawler, want)
+	}
+	automover, err := m.SlabAutomoverStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"slab_reassign": "yes", "slab_automove": "1", "slabs_moved": "4"}; fmt.Sprint(automover) != fmt.Sprint(want) {
+		t.Fatalf("SlabAutomoverStatus = %q, want %q", automover, want)
+	}
+	if got := tr.written.String(); got != "stats settings\r\nstats\r\nstats settings\r\nstats\r\n" {
+		t.Fatalf("written %q", got)
+	}
+}
+
+func TestSetMemLimit(t *testing.T) {
+	m, _, tr := newTestClient(t)
+	if err := m.SetMemLimit(128); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.written.String(); got != "cache_memlimit 128\r\n" {
+		t.Fatalf("written %q", got)
+	}
+	for _, mb := range []int{0, -64} {
+		if err := m.SetMemLimit(mb); !errors.Is(err, ErrInvalidArgument) {
+			t.Fatalf("SetMemLimit(%d) = %v, want ErrInvalidArgument", mb, err)
+		}
+	}
+	if got := tr.written.String(); got != "cache_memlimit 128\r\n" {
+		t.Fatalf("an invalid limit was sent: %q", got)
+	}
+}
+
+func TestSlabReassign(t *testing.T) {
+	tr := newScriptTransport("OK\r\n", "BUSY currently processing reassign request\r\n", "BADCLASS invalid src or dst class id\r\n")
+	m := NewMemcachedWithTransport(tr)
+	if err := m.SlabReassign(-1, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SlabReassign(1, 2); !errors.Is(err, ErrBusy) {
+		t.Fatalf("SlabReassign answered BUSY = %v, want ErrBusy", err)
+	}
+	if err := m.SlabReassign(1, 99); !errors.Is(err, ErrBadClass) {
+		t.Fatalf("SlabReassign answered BADCLASS = %v, want ErrBadClass", err)
+	}
+	want := "slabs reassign -1 5\r\nslabs reassign 1 2\r\nslabs reassign 1 99\r\n"
+	if got := tr.written.String(); got != want {
+		t.Fatalf("written %q, want %q", got, want)
+	}
+	if tr.connects != 1 {
+		t.Fatalf("connects = %d, want BUSY and BADCLASS to keep the connection", tr.connects)
+	}
+	for _, classes := range [][2]int{{-2, 1}, {1, -1}} {
+		if err := m.SlabReassign(classes[0], classes[1]); !errors.Is(err, ErrInvalidArgument) {
+			t.Fatalf("SlabReassign(%d, %d) = %v, want ErrInvalidArgument", classes[0], classes[1], err)
+		}
+	}
+}
+
+func TestVerbosity(t *testing.T) {
+	m, _, tr := newTestClient(t)
+	if err := m.Verbosity(1); err != nil {
+		t.Fatal(err)
+	}
+	for _, level := range []int{-1, 4} {
+		if err := m.Verbosity(level); !errors.Is(err, ErrInvalidArgument) {
+			t.Fatalf("Verbosity(%d) = %v, want ErrInvalidArgument", level, err)
+		}
+	}
+	if got := tr.written.String(); got != "verbosity 1\r\n" {
+		t.Fatalf("written %q", got)
+	}
+}
+
+func TestMaxKeyLength(t *testing.T) {
+	long := Key(strings.Repeat("k", 300))
+	m, _, _ := newTestClient(t)
+	if err := m.Set(long, "v", 0); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("Set of a 300 byte key with the default limit = %v", err)
+	}
+	if err := m.Set(Key(strings.Repeat("k", 250)), "v", 0); err != nil {
+		t.Fatalf("Set of a 250 byte key = %v", err)
+	}
+
+	extended, _, _ := newTestClient(t, WithMaxKeyLength(400))
+	if err := extended.Set(long, "v", 0); err != nil {
+		t.Fatalf("Set of a 300 byte key with a 400 byte limit = %v", err)
+	}
+	if err := extended.Set(Key(strings.Repeat("k", 401)), "v", 0); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("Set of a 401 byte key with a 400 byte limit = %v", err)
+	}
+	if err := extended.ValidateKey(long); err != nil {
+		t.Fatalf("ValidateKey = %v", err)
+	}
+	if err := long.Validate(); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("Key.Validate with the default limit = %v", err)
+	}
+}
+
+func TestGetCas(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("k", "v", 0)
+	value, cas, found, err := m.GetCas("k")
+	if err != nil || !found || value != "v" || cas == 0 {
+		t.Fatalf("GetCas of a hit = %q, %d, %v, %v", value, cas, found, err)
+	}
+	if err := m.Cas("k", "w", 0, cas); err != nil {
+		t.Fatalf("Cas with the GetCas token = %v", err)
+	}
+	value, cas, found, err = m.GetCas("missing")
+	if err != nil || found || value != "" || cas != 0 {
+		t.Fatalf("GetCas of a miss = %q, %d, %v, %v", value, cas, found, err)
+	}
+	if !strings.HasPrefix(tr.written.String(), "gets k\r\n") {
+		t.Fatalf("written %q, want gets", tr.written.String())
+	}
+}
+
+func TestGetMultiSplitsLongRequests(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	var keys []Key
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key:%04d", i)
+		keys = append(keys, Key(key))
+		if i%3 != 0 {
+			srv.put(key, "value "+key, 0)
+		}
+	}
+	values, err := m.GetMulti(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 666 {
+		t.Fatalf("GetMulti = %d values, want 666", len(values))
+	}
+	for key, value := range values {
+		if value != "value "+string(key) {
+			t.Fatalf("values[%s] = %q", key, value)
+		}
+	}
+	lines := strings.Split(strings.TrimSuffix(tr.written.String(), "\r\n"), "\r\n")
+	if len(lines) < 2 {
+		t.Fatalf("%d get commands, want the request split", len(lines))
+	}
+	for _, line := range lines {
+		if len(line) > defaultMaxGetLine {
+			t.Fatalf("get command of %d bytes exceeds %d", len(line), defaultMaxGetLine)
+		}
+	}
+}
+
+func TestSplitGetCommands(t *testing.T) {
+	got := splitGetCommands("get", []string{"aa", "bb", "cc"}, 9)
+	if fmt.Sprint(got) != "[get aa bb get cc]" {
+		t.Fatalf("splitGetCommands = %q", got)
+	}
+	if got := splitGetCommands("get", []string{"a-very-long-key"}, 4); fmt.Sprint(got) != "[get a-very-long-key]" {
+		t.Fatalf("splitGetCommands with one long key = %q", got)
+	}
+}
+
+func TestSetAndVerify(t *testing.T) {
+	m, _, _ := newTestClient(t)
+	if err := m.SetAndVerify("k", "v", 0); err != nil {
+		t.Fatalf("SetAndVerify = %v", err)
+	}
+
+	evicting := NewMemcachedWithTransport(newScriptTransport("STORED\r\n", "END\r\n"))
+	if err := evicting.SetAndVerify("k", "v", 0); !errors.Is(err, ErrLikelyEvicted) {
+		t.Fatalf("SetAndVerify of an evicted value = %v, want ErrLikelyEvicted", err)
+	}
+
+	changed := NewMemcachedWithTransport(newScriptTransport("STORED\r\n", "VALUE k 0 1 7\r\nw\r\nEND\r\n"))
+	if err := changed.SetAndVerify("k", "v", 0); err == nil || errors.Is(err, ErrLikelyEvicted) {
+		t.Fatalf("SetAndVerify of an overwritten value = %v", err)
+	}
+}
@@ -0,0 +1,335 @@
+package memcached
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func (m *Memcached) MetaGet(key Key, flags string) (value string, metaFlags map[string]string, err error) {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return "", nil, validKeyErr
+	}
+	validFlagsErr := validMetaFlags(flags)
+	if validFlagsErr != nil {
+		return "", nil, validFlagsErr
+	}
+
+	err = m.exec(context.Background(), "mg", func(t Transport) error {
+		resp, err := m.request(context.Background(), t, metaCommand("mg", key, flags))
+		if err != nil {
+			return err
+		}
+		code, tokens := splitMetaReply(resp)
+		switch code {
+		case "EN":
+			return ErrNotFound
+		case "HD":
+			metaFlags = parseMetaFlags(tokens)
+			return nil
+		case "VA":
+		default:
+			return &ProtocolError{Stage: "header", Line: resp}
+		}
+
+		if len(tokens) == 0 {
+			return &ProtocolError{Stage: "header", Line: resp}
+		}
+		bytes, err := strconv.Atoi(tokens[0])
+		if err != nil || bytes < 0 {
+			return &ProtocolError{Stage: "header", Line: resp}
+		}
+		value, err = m.readBody(t, bytes)
+		if err != nil {
+			return err
+		}
+		metaFlags = parseMetaFlags(tokens[1:])
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return value, metaFlags, nil
+}
+
+// Exists falls back to a plain get when the server does not know the meta
+// commands, which transfers the value.
+func (m *Memcached) Exists(key Key) (bool, error) {
+	_, _, err := m.MetaGet(key, "")
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if !isNonexistentCommand(err) {
+		return false, err
+	}
+	_, found, err := m.GetOK(key)
+	return found, err
+}
+
+func (m *Memcached) MetaSet(key Key, value string, flags string) (map[string]string, error) {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return nil, validKeyErr
+	}
+	validFlagsErr := validMetaFlags(flags)
+	if validFlagsErr != nil {
+		return nil, validFlagsErr
+	}
+	validSizeErr := m.validValueSize(value)
+	if validSizeErr != nil {
+		return nil, validSizeErr
+	}
+
+	cmd := fmt.Sprintf("ms %s %d", key, len(value))
+	if flags != "" {
+		cmd += " " + flags
+	}
+	resp, err := m.command(cmd + "\r\n" + value)
+	if err != nil {
+		return nil, err
+	}
+	code, tokens := splitMetaReply(resp)
+	switch code {
+	case "HD":
+		return parseMetaFlags(tokens), nil
+	case "NS":
+		return nil, ErrNotStored
+	case "EX":
+		return nil, ErrCASConflict
+	case "NF":
+		return nil, ErrNotFound
+	}
+	return nil, &ProtocolError{Stage: "header", Line: resp}
+}
+
+// MetaDelete with the quiet flag q gets no reply on success, so the command
+// is followed by mn and an immediate MN reply counts as a delete. The server
+// hides NF in quiet mode as well, so ErrNotFound cannot be reported with q.
+func (m *Memcached) MetaDelete(key Key, flags string) (map[string]string, error) {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return nil, validKeyErr
+	}
+	validFlagsErr := validMetaFlags(flags)
+	if validFlagsErr != nil {
+		return nil, validFlagsErr
+	}
+
+	cmd := metaCommand("md", key, flags)
+	quiet := hasMetaFlag(flags, "q")
+	if quiet {
+		cmd += "\r\nmn"
+	}
+	var resp string
+	err := m.exec(context.Background(), "md", func(t Transport) error {
+		var err error
+		resp, err = m.request(context.Background(), t, cmd)
+		if err != nil || !quiet || resp == "MN\r\n" {
+			return err
+		}
+		return m.readNoop(t)
+	})
+	if err != nil {
+		return nil, err
+	}
+	code, tokens := splitMetaReply(resp)
+	switch code {
+	case "HD":
+		return parseMetaFlags(tokens), nil
+	case "MN":
+		return map[string]string{}, nil
+	case "NF":
+		return nil, ErrNotFound
+	}
+	return nil, &ProtocolError{Stage: "header", Line: resp}
+}
+
+func (m *Memcached) MetaNoop() error {
+	return m.exec(context.Background(), "mn", func(t Transport) error {
+		resp, err := m.request(context.Background(), t, "mn")
+		if err != nil {
+			return err
+		}
+		if resp != "MN\r\n" {
+			return &ProtocolError{Stage: "header", Line: resp}
+		}
+		return nil
+	})
+}
+
+// MetaGetMulti sends a quiet mg for every key and ends the batch with mn.
+// Misses get no reply, so every reply up to MN is a hit. The client flags
+// are requested so that compressed values are decoded as by GetMulti.
+func (m *Memcached) MetaGetMulti(keys []Key) (map[Key]string, error) {
+	items := make(map[Key]string, len(keys))
+	if len(keys) == 0 {
+		return items, nil
+	}
+	cmds := make([]string, 0, len(keys)+1)
+	for _, key := range keys {
+		full, validKeyErr := m.validKey(key)
+		if validKeyErr != nil {
+			return nil, validKeyErr
+		}
+		cmds = append(cmds, metaCommand("mg", full, "v f k q"))
+	}
+	cmds = append(cmds, "mn")
+
+	err := m.exec(context.Background(), "mg", func(t Transport) error {
+		resp, err := m.request(context.Background(), t, strings.Join(cmds, "\r\n"))
+		for err == nil && resp != "MN\r\n" {
+			code, tokens := splitMetaReply(resp)
+			if code != "VA" || len(tokens) == 0 {
+				return &ProtocolError{Stage: "header", Line: resp}
+			}
+			bytes, convErr := strconv.Atoi(tokens[0])
+			if convErr != nil || bytes < 0 {
+				return &ProtocolError{Stage: "header", Line: resp}
+			}
+			flags := parseMetaFlags(tokens[1:])
+			key, ok := flags["k"]
+			if !ok || !strings.HasPrefix(key, m.prefix) {
+				return &ProtocolError{Stage: "header", Line: resp}
+			}
+			clientFlags, convErr := strconv.ParseUint(flags["f"], 10, 32)
+			if convErr != nil {
+				return &ProtocolError{Stage: "header", Line: resp}
+			}
+			var value string
+			value, err = m.readBody(t, bytes)
+			if err != nil {
+				return err
+			}
+			value, _, err = decodeValue(value, uint32(clientFlags))
+			if err != nil {
+				return err
+			}
+			items[Key(strings.TrimPrefix(key, m.prefix))] = value
+			resp, err = m.readLine(t)
+			if err == nil {
+				err = replyError("mg", resp)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return items, err
+	}
+	return items, nil
+}
+
+func (m *Memcached) readNoop(t Transport) error {
+	noop, err := m.readLine(t)
+	if err != nil {
+		return err
+	}
+	if noop != "MN\r\n" {
+		return &ProtocolError{Stage: "header", Line: noop}
+	}
+	return nil
+}
+
+func (m *Memcached) MetaArithmetic(key Key, flags string) (value uint64, meta map[string]string, err error) {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return 0, nil, validKeyErr
+	}
+	validFlagsErr := validMetaFlags(flags)
+	if validFlagsErr != nil {
+		return 0, nil, validFlagsErr
+	}
+
+	err = m.exec(context.Background(), "ma", func(t Transport) error {
+		resp, err := m.request(context.Background(), t, metaCommand("ma", key, flags))
+		if err != nil {
+			return err
+		}
+		code, tokens := splitMetaReply(resp)
+		switch code {
+		case "NF":
+			return ErrNotFound
+		case "NS":
+			return ErrNotStored
+		case "EX":
+			return ErrCASConflict
+		case "HD":
+			meta = parseMetaFlags(tokens)
+			return nil
+		case "VA":
+		default:
+			return &ProtocolError{Stage: "header", Line: resp}
+		}
+
+		if len(tokens) == 0 {
+			return &ProtocolError{Stage: "header", Line: resp}
+		}
+		bytes, err := strconv.Atoi(tokens[0])
+		if err != nil || bytes < 0 {
+			return &ProtocolError{Stage: "header", Line: resp}
+		}
+		body, err := m.readBody(t, bytes)
+		if err != nil {
+			return err
+		}
+		value, err = strconv.ParseUint(body, 10, 64)
+		if err != nil {
+			return &ProtocolError{Stage: "body", Line: body}
+		}
+		meta = parseMetaFlags(tokens[1:])
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return value, meta, nil
+}
+
+func isNonexistentCommand(err error) bool {
+	var serverErr *ServerError
+	return errors.Is(err, ErrServerError) && !errors.As(err, &serverErr)
+}
+
+func metaCommand(verb string, key Key, flags string) string {
+	if flags == "" {
+		return fmt.Sprintf("%s %s", verb, key)
+	}
+	return fmt.Sprintf("%s %s %s", verb, key, flags)
+}
+
+func validMetaFlags(flags string) error {
+	if strings.ContainsAny(flags, "\r\n") {
+		return fmt.Errorf("invalid meta flags: %q: %w", flags, ErrInvalidArgument)
+	}
+	return nil
+}
+
+func hasMetaFlag(flags string, flag string) bool {
+	for _, token := range strings.Fields(flags) {
+		if token == flag {
+			return true
+		}
+	}
+	return false
+}
+
+func splitMetaReply(resp string) (string, []string) {
+	fields := strings.Fields(resp)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], fields[1:]
+}
+
+func parseMetaFlags(tokens []string) map[string]string {
+	flags := make(map[string]string, len(tokens))
+	for _, token := range tokens {
+		flags[token[:1]] = token[1:]
+	}
+	return flags
+}
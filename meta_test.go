@@ -0,0 +1,255 @@
+package memcached
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMetaGet(t *testing.T) {
+	tests := []struct {
+		name      string
+		flags     string
+		reply     string
+		wantValue string
+		wantFlags map[string]string
+		wantErr   error
+	}{
+		{"value with body", "v t f", "VA 5 t123 f7\r\nhello\r\n", "hello", map[string]string{"t": "123", "f": "7"}, nil},
+		{"header without body", "t f", "HD t-1 f0\r\n", "", map[string]string{"t": "-1", "f": "0"}, nil},
+		{"miss", "v", "EN\r\n", "", nil, ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newScriptTransport(tt.reply)
+			value, flags, err := NewMemcachedWithTransport(tr).MetaGet("k", tt.flags)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("MetaGet error = %v, want %v", err, tt.wantErr)
+			}
+			if value != tt.wantValue || fmt.Sprint(flags) != fmt.Sprint(tt.wantFlags) {
+				t.Fatalf("MetaGet = %q, %q, want %q, %q", value, flags, tt.wantValue, tt.wantFlags)
+			}
+			if got := tr.written.String(); got != "mg k "+tt.flags+"\r\n" {
+				t.Fatalf("written %q", got)
+			}
+		})
+	}
+}
+
+func TestMetaGetMalformed(t *testing.T) {
+	for _, reply := range []string{"VA\r\n", "VA x\r\n", "XX\r\n"} {
+		_, _, err := NewMemcachedWithTransport(newScriptTransport(reply)).MetaGet("k", "v")
+		var protoErr *ProtocolError
+		if !errors.As(err, &protoErr) {
+			t.Fatalf("MetaGet with %q = %v, want a ProtocolError", reply, err)
+		}
+	}
+	if _, _, err := NewMemcachedWithTransport(newScriptTransport()).MetaGet("k", "v\r\n"); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("MetaGet with bad flags = %v", err)
+	}
+}
+
+func TestMetaSet(t *testing.T) {
+	tests := []struct {
+		name      string
+		reply     string
+		wantFlags map[string]string
+		wantErr   error
+	}{
+		{"stored", "HD c42\r\n", map[string]string{"c": "42"}, nil},
+		{"not stored", "NS\r\n", nil, ErrNotStored},
+		{"exists", "EX\r\n", nil, ErrCASConflict},
+		{"not found", "NF\r\n", nil, ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newScriptTransport(tt.reply)
+			flags, err := NewMemcachedWithTransport(tr).MetaSet("k", "a\r\nb", "c C41")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("MetaSet error = %v, want %v", err, tt.wantErr)
+			}
+			if fmt.Sprint(flags) != fmt.Sprint(tt.wantFlags) {
+				t.Fatalf("MetaSet flags = %q, want %q", flags, tt.wantFlags)
+			}
+			if got := tr.written.String(); got != "ms k 4 c C41\r\na\r\nb\r\n" {
+				t.Fatalf("written %q", got)
+			}
+		})
+	}
+}
+
+func TestMetaSetCAS(t *testing.T) {
+	m, srv, _ := newTestClient(t)
+	flags, err := m.MetaSet("k", "1", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.MetaSet("k", "2", "C"+flags["c"]); err != nil {
+		t.Fatalf("MetaSet with the current cas = %v", err)
+	}
+	if _, err := m.MetaSet("k", "3", "C"+flags["c"]); !errors.Is(err, ErrCASConflict) {
+		t.Fatalf("MetaSet with a stale cas = %v, want ErrCASConflict", err)
+	}
+	if it, _ := srv.item("k"); string(it.value) != "2" {
+		t.Fatalf("value = %q, want 2", it.value)
+	}
+}
+
+func TestMetaDelete(t *testing.T) {
+	tests := []struct {
+		name      string
+		flags     string
+		replies   []string
+		wantCmd   string
+		wantFlags map[string]string
+		wantErr   error
+	}{
+		{"deleted", "I T30", []string{"HD\r\n"}, "md k I T30\r\n", map[string]string{}, nil},
+		{"deleted with flags", "k", []string{"HD kk\r\n"}, "md k k\r\n", map[string]string{"k": "k"}, nil},
+		{"not found", "", []string{"NF\r\n"}, "md k\r\n", nil, ErrNotFound},
+		{"quiet", "q", []string{"MN\r\n"}, "md k q\r\nmn\r\n", map[string]string{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newScriptTransport(tt.replies...)
+			flags, err := NewMemcachedWithTransport(tr).MetaDelete("k", tt.flags)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("MetaDelete error = %v, want %v", err, tt.wantErr)
+			}
+			if fmt.Sprint(flags) != fmt.Sprint(tt.wantFlags) {
+				t.Fatalf("MetaDelete flags = %q, want %q", flags, tt.wantFlags)
+			}
+			if got := tr.written.String(); got != tt.wantCmd {
+				t.Fatalf("written %q, want %q", got, tt.wantCmd)
+			}
+		})
+	}
+}
+
+func TestMetaDeleteQuietKeepsStreamInSync(t *testing.T) {
+	m, srv, _ := newTestClient(t)
+	srv.put("k", "v", 0)
+	if _, err := m.MetaDelete("k", "q"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := srv.item("k"); found {
+		t.Fatal("quiet md did not delete")
+	}
+	if _, err := m.MetaDelete("k", "q"); err != nil {
+		t.Fatalf("quiet md of a miss = %v, want the NF hidden like HD", err)
+	}
+	if err := m.MetaNoop(); err != nil {
+		t.Fatalf("mn after quiet deletes = %v", err)
+	}
+}
+
+func TestMetaArithmetic(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("n", "10", 0)
+
+	if value, _, err := m.MetaArithmetic("n", "v MI D5"); err != nil || value != 15 {
+		t.Fatalf("increment = %d, %v, want 15", value, err)
+	}
+	if value, _, err := m.MetaArithmetic("n", "v MD D100"); err != nil || value != 0 {
+		t.Fatalf("decrement below zero = %d, %v, want 0", value, err)
+	}
+	if value, _, err := m.MetaArithmetic("fresh", "v N0 J42"); err != nil || value != 42 {
+		t.Fatalf("auto-create = %d, %v, want 42", value, err)
+	}
+	if it, found := srv.item("fresh"); !found || string(it.value) != "42" {
+		t.Fatalf("auto-created item = %v, %v", it, found)
+	}
+	if _, _, err := m.MetaArithmetic("missing", "v"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ma of a miss = %v, want ErrNotFound", err)
+	}
+	if value, meta, err := m.MetaArithmetic("n", ""); err != nil || value != 0 || meta == nil {
+		t.Fatalf("ma without v = %d, %v, %v", value, meta, err)
+	}
+	want := "ma n v MI D5\r\nma n v MD D100\r\nma fresh v N0 J42\r\nma missing v\r\nma n\r\n"
+	if got := tr.written.String(); got != want {
+		t.Fatalf("written %q, want %q", got, want)
+	}
+
+	bad := NewMemcachedWithTransport(newScriptTransport("VA 2\r\nxx\r\n"))
+	var protoErr *ProtocolError
+	if _, _, err := bad.MetaArithmetic("n", "v"); !errors.As(err, &protoErr) {
+		t.Fatalf("ma with a non-numeric body = %v, want a ProtocolError", err)
+	}
+}
+
+func TestExists(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("k", strings.Repeat("large value ", 1000), 0)
+	if found, err := m.Exists("k"); err != nil || !found {
+		t.Fatalf("Exists of a present key = %v, %v", found, err)
+	}
+	if found, err := m.Exists("missing"); err != nil || found {
+		t.Fatalf("Exists of an absent key = %v, %v", found, err)
+	}
+	if got := tr.written.String(); got != "mg k\r\nmg missing\r\n" {
+		t.Fatalf("written %q, want mg without v", got)
+	}
+	if tr.reads != 2 {
+		t.Fatalf("%d reads, want no value transferred", tr.reads)
+	}
+}
+
+func TestExistsFallsBackWithoutMeta(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.noMeta = true
+	srv.put("k", "v", 0)
+	if found, err := m.Exists("k"); err != nil || !found {
+		t.Fatalf("Exists of a present key = %v, %v", found, err)
+	}
+	if found, err := m.Exists("missing"); err != nil || found {
+		t.Fatalf("Exists of an absent key = %v, %v", found, err)
+	}
+	if got := tr.written.String(); got != "mg k\r\nget k\r\nmg missing\r\nget missing\r\n" {
+		t.Fatalf("written %q", got)
+	}
+}
+
+func TestMetaNoop(t *testing.T) {
+	tr := newScriptTransport("MN\r\n", "HD\r\n")
+	m := NewMemcachedWithTransport(tr)
+	if err := m.MetaNoop(); err != nil {
+		t.Fatal(err)
+	}
+	if got := tr.written.String(); got != "mn\r\n" {
+		t.Fatalf("written %q", got)
+	}
+	var protoErr *ProtocolError
+	if err := m.MetaNoop(); !errors.As(err, &protoErr) {
+		t.Fatalf("MetaNoop answered with HD = %v, want a ProtocolError", err)
+	}
+}
+
+func TestMetaGetMulti(t *testing.T) {
+	m, srv, tr := newTestClient(t, WithPrefix("app:"), WithCompression(64))
+	large := strings.Repeat("compressible ", 100)
+	if err := m.Set("big", large, 0); err != nil {
+		t.Fatal(err)
+	}
+	if it, _ := srv.item("app:big"); it.flags&FlagCompressed == 0 {
+		t.Fatal("the value was not stored compressed")
+	}
+	srv.put("app:small", "s", 0)
+	tr.written.Reset()
+
+	values, err := m.MetaGetMulti([]Key{"big", "missing", "small"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values["big"] != large || values["small"] != "s" {
+		t.Fatalf("MetaGetMulti = %d values, big %d bytes, small %q", len(values), len(values["big"]), values["small"])
+	}
+	want := "mg app:big v f k q\r\nmg app:missing v f k q\r\nmg app:small v f k q\r\nmn\r\n"
+	if got := tr.written.String(); got != want {
+		t.Fatalf("written %q, want %q", got, want)
+	}
+
+	if value, err := m.Get("small"); err != nil || value != "s" {
+		t.Fatalf("Get after MetaGetMulti = %q, %v, want the stream drained", value, err)
+	}
+}
@@ -0,0 +1,117 @@
+package memcached
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyMeta describes one item of a metadump. Expiration is -1 for items
+// that never expire, otherwise it and LastAccess are Unix times.
+type KeyMeta struct {
+	Key        Key
+	Expiration int64
+	LastAccess int64
+	CAS        uint64
+	Fetched    bool
+	Class      int
+	Size       int
+}
+
+func (m *Memcached) MetaDumpAll() ([]KeyMeta, error) {
+	lines, err := m.commandMultiline("lru_crawler metadump all", "END")
+	if err != nil {
+		return nil, err
+	}
+	metas := make([]KeyMeta, 0, len(lines))
+	for _, line := range lines {
+		meta, err := parseKeyMeta(line)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(string(meta.Key), m.prefix) {
+			continue
+		}
+		meta.Key = Key(strings.TrimPrefix(string(meta.Key), m.prefix))
+		metas = append(metas, meta)
+	}
+	return metas, nil
+}
+
+// KeyMeta inspects a single item with a meta get, without transferring
+// its value. Class and Size are not reported this way and stay zero.
+func (m *Memcached) KeyMeta(key Key) (*KeyMeta, error) {
+	_, flags, err := m.MetaGet(key, "t l h c")
+	if err != nil {
+		return nil, err
+	}
+	meta, err := metaGetKeyMeta(key, flags, m.now())
+	if err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func metaGetKeyMeta(key Key, flags map[string]string, now time.Time) (KeyMeta, error) {
+	meta := KeyMeta{Key: key, Expiration: -1, Fetched: flags["h"] == "1"}
+	var err error
+	if ttl, ok := flags["t"]; ok && ttl != "-1" {
+		meta.Expiration, err = strconv.ParseInt(ttl, 10, 64)
+		if err != nil {
+			return meta, &ProtocolError{Stage: "header", Line: "t" + ttl}
+		}
+		meta.Expiration += now.Unix()
+	}
+	if idle, ok := flags["l"]; ok {
+		meta.LastAccess, err = strconv.ParseInt(idle, 10, 64)
+		if err != nil {
+			return meta, &ProtocolError{Stage: "header", Line: "l" + idle}
+		}
+		meta.LastAccess = now.Unix() - meta.LastAccess
+	}
+	if cas, ok := flags["c"]; ok {
+		meta.CAS, err = strconv.ParseUint(cas, 10, 64)
+		if err != nil {
+			return meta, &ProtocolError{Stage: "header", Line: "c" + cas}
+		}
+	}
+	return meta, nil
+}
+
+func parseKeyMeta(line string) (KeyMeta, error) {
+	var meta KeyMeta
+	var hasKey bool
+	for _, field := range strings.Fields(line) {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return meta, &ProtocolError{Stage: "body", Line: line}
+		}
+		var err error
+		switch name {
+		case "key":
+			var k string
+			k, err = url.QueryUnescape(value)
+			meta.Key, hasKey = Key(k), true
+		case "exp":
+			meta.Expiration, err = strconv.ParseInt(value, 10, 64)
+		case "la":
+			meta.LastAccess, err = strconv.ParseInt(value, 10, 64)
+		case "cas":
+			meta.CAS, err = strconv.ParseUint(value, 10, 64)
+		case "fetch":
+			meta.Fetched = value == "yes"
+		case "cls":
+			meta.Class, err = strconv.Atoi(value)
+		case "size":
+			meta.Size, err = strconv.Atoi(value)
+		}
+		if err != nil {
+			return meta, &ProtocolError{Stage: "body", Line: line}
+		}
+	}
+	if !hasKey {
+		return meta, &ProtocolError{Stage: "body", Line: line}
+	}
+	return meta, nil
+}
@@ -0,0 +1,65 @@
+package memcached
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMetaDumpAll(t *testing.T) {
+	tr := newScriptTransport("key=user%3A1%20a exp=-1 la=1700000000 cas=7 fetch=yes cls=1 size=68\r\n" +
+		"key=app%3Aplain exp=1700003600 la=1700000100 cas=8 fetch=no cls=2 size=120\r\nEND\r\n")
+	metas, err := NewMemcachedWithTransport(tr).MetaDumpAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []KeyMeta{
+		{Key: "user:1 a", Expiration: -1, LastAccess: 1700000000, CAS: 7, Fetched: true, Class: 1, Size: 68},
+		{Key: "app:plain", Expiration: 1700003600, LastAccess: 1700000100, CAS: 8, Class: 2, Size: 120},
+	}
+	if !reflect.DeepEqual(metas, want) {
+		t.Fatalf("MetaDumpAll = %+v, want %+v", metas, want)
+	}
+	if got := tr.written.String(); got != "lru_crawler metadump all\r\n" {
+		t.Fatalf("written %q", got)
+	}
+
+	tr = newScriptTransport("key=user%3A1 exp=-1\r\nkey=app%3Aplain exp=-1\r\nEND\r\n")
+	metas, err = NewMemcachedWithTransport(tr, WithPrefix("app:")).MetaDumpAll()
+	if err != nil || len(metas) != 1 || metas[0].Key != "plain" {
+		t.Fatalf("MetaDumpAll with a prefix = %+v, %v", metas, err)
+	}
+
+	tr = newScriptTransport("garbage\r\nEND\r\n")
+	_, err = NewMemcachedWithTransport(tr).MetaDumpAll()
+	var protoErr *ProtocolError
+	if !errors.As(err, &protoErr) || protoErr.Stage != "body" {
+		t.Fatalf("MetaDumpAll of a garbage line = %v, want a ProtocolError", err)
+	}
+}
+
+func TestKeyMeta(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tr := newScriptTransport("HD t60 l5 h1 c42\r\n", "HD t-1 l0 h0 c43\r\n", "EN\r\n")
+	m := NewMemcachedWithTransport(tr, WithClock(func() time.Time { return now }))
+	meta, err := m.KeyMeta("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := KeyMeta{Key: "k", Expiration: 1700000060, LastAccess: 1699999995, CAS: 42, Fetched: true}
+	if *meta != want {
+		t.Fatalf("KeyMeta = %+v, want %+v", *meta, want)
+	}
+	if got := tr.written.String(); got != "mg k t l h c\r\n" {
+		t.Fatalf("written %q", got)
+	}
+
+	meta, err = m.KeyMeta("forever")
+	if err != nil || meta.Expiration != -1 || meta.Fetched {
+		t.Fatalf("KeyMeta of an item without expiry = %+v, %v", meta, err)
+	}
+	if _, err := m.KeyMeta("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("KeyMeta of a miss = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,78 @@
+package memcached
+
+import (
+	"strings"
+	"time"
+)
+
+// A noreply command leaves nothing to read on success, but the server may
+// still answer it with an error line. A connection that has sent noreply
+// commands is reconciled before the next command that expects a reply: a
+// version request is written as a barrier and everything read before its
+// reply is discarded. Commands sent outside this client on the same
+// connection cannot be tracked.
+
+const drainTimeout = 100 * time.Millisecond
+
+func (m *Memcached) setPending(t Transport, pending bool) {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	if !pending {
+		delete(m.pending, t)
+		return
+	}
+	if m.pending == nil {
+		m.pending = make(map[Transport]bool)
+	}
+	m.pending[t] = true
+}
+
+func (m *Memcached) isPending(t Transport) bool {
+	m.pendingMu.Lock()
+	defer m.pendingMu.Unlock()
+	return m.pending[t]
+}
+
+func (m *Memcached) reconcile(t Transport) error {
+	if !m.isPending(t) {
+		return nil
+	}
+	m.setPending(t, false)
+
+	writeErr := m.write(t, "version")
+	if writeErr != nil {
+		return writeErr
+	}
+	for {
+		line, err := m.readLine(t)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, "VERSION ") {
+			return nil
+		}
+		if m.logger != nil {
+			m.logger.Printf("memcached: discarded %q", line)
+		}
+	}
+}
+
+// Drain reads and discards pending output of noreply commands on idle
+// connections, waiting at most a short deadline on each of them.
+func (m *Memcached) Drain() error {
+	var firstErr error
+	for _, t := range m.pool.takeIdle() {
+		if !m.isPending(t) {
+			m.pool.put(t, false)
+			continue
+		}
+		t.SetDeadline(time.Now().Add(drainTimeout))
+		err := m.reconcile(t)
+		t.SetDeadline(time.Time{})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		m.release(t, err)
+	}
+	return firstErr
+}
@@ -0,0 +1,117 @@
+package memcached
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+func TestNoReplyThenGet(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("k", "old", 0)
+	if err := m.SetNoReply("k", "new", 0); err != nil {
+		t.Fatal(err)
+	}
+	if !m.isPending(tr) {
+		t.Fatal("noreply set left nothing to reconcile")
+	}
+	if value, err := m.Get("k"); err != nil || value != "new" {
+		t.Fatalf("Get after a noreply set = %q, %v", value, err)
+	}
+	if m.isPending(tr) {
+		t.Fatal("Get did not reconcile the connection")
+	}
+	if got := tr.written.String(); got != "set k 0 0 3 noreply\r\nnew\r\nversion\r\nget k\r\n" {
+		t.Fatalf("written %q", got)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	tr := newScriptTransport("SERVER_ERROR out of memory\r\n", "VERSION 1.6.21\r\n")
+	logs := &logBuffer{}
+	m := NewMemcachedWithTransport(tr, WithLogger(logs))
+	if err := m.SetNoReply("k", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	if m.isPending(tr) {
+		t.Fatal("Drain left the connection pending")
+	}
+	if !tr.deadline.IsZero() || len(tr.deadlines) != 2 {
+		t.Fatalf("Drain deadlines = %v, want one set and cleared", tr.deadlines)
+	}
+	if err := m.Drain(); err != nil || len(tr.deadlines) != 2 {
+		t.Fatalf("Drain of a reconciled connection = %v, %d deadlines", err, len(tr.deadlines))
+	}
+}
+
+func TestBrokenTransportIsNotPending(t *testing.T) {
+	m, _, tr := newTestClient(t)
+	if err := m.SetNoReply("a", "1", 0); err != nil {
+		t.Fatal(err)
+	}
+	tr.flushErrs = []error{syscall.EPIPE}
+	if err := m.DeleteNoReply("a"); err == nil {
+		t.Fatal("DeleteNoReply on a broken connection succeeded")
+	}
+	if m.isPending(tr) {
+		t.Fatal("a closed transport is still tracked as pending")
+	}
+}
+
+func TestDrainConcurrentWithGet(t *testing.T) {
+	srv := newMemServer()
+	srv.put("k", "v", 0)
+	pooled, err := NewMemcachedPool("tcp", serveTCP(t, srv), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pooled.Close)
+	single, _, tr := newTestClient(t)
+	_ = single.Set("k", "v", 0)
+
+	for _, m := range []*Memcached{pooled, single} {
+		var wg sync.WaitGroup
+		errs := make(chan error, 200)
+		for g := 0; g < 4; g++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 25; i++ {
+					_ = m.SetNoReply("other", "x", 0)
+					if err := m.Drain(); err != nil {
+						errs <- err
+					}
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 25; i++ {
+					if value, err := m.Get("k"); err != nil || value != "v" {
+						errs <- fmt.Errorf("Get during Drain = %q, %v", value, err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+		m.pool.mu.Lock()
+		idle := len(m.pool.idle)
+		m.pool.mu.Unlock()
+		if idle != 1 {
+			t.Errorf("%d idle transports, want the single connection once", idle)
+		}
+	}
+	if got := srv.conns.Load(); got != 1 {
+		t.Fatalf("connections = %d, want Drain to stay within the pool cap", got)
+	}
+	if tr.connects != 1 {
+		t.Fatalf("connects = %d, want the single transport never redialed", tr.connects)
+	}
+}
@@ -0,0 +1,42 @@
+package memcached
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+const FlagJSON uint32 = 1 << 1
+
+func (m *Memcached) SetObject(key Key, v interface{}, ttl TTL) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("cannot marshal value: %q\n", err)
+	}
+	return m.setWithFlags(key, string(data), FlagJSON, ttl)
+}
+
+func (m *Memcached) GetObject(key Key, dest interface{}) error {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return validKeyErr
+	}
+
+	cmd := fmt.Sprintf("get %s", key)
+	items, err := m.retrieve(context.Background(), cmd)
+	err = m.bestEffort(err)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return ErrNotFound
+	}
+	if items[0].flags&FlagJSON == 0 {
+		return fmt.Errorf("value is not json encoded: flags %d\n", items[0].flags)
+	}
+	err = json.Unmarshal([]byte(items[0].value), dest)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal value: %q\n", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package memcached
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testObject struct {
+	Name   string            `json:"name"`
+	Tags   []string          `json:"tags"`
+	Inner  *testObject       `json:"inner,omitempty"`
+	Labels map[string]string `json:"labels"`
+}
+
+func TestObjectRoundTrip(t *testing.T) {
+	m, srv, _ := newTestClient(t)
+	in := testObject{
+		Name:   "outer \x00\x7f é 😀\r\n",
+		Tags:   []string{"a", "b"},
+		Inner:  &testObject{Name: "inner", Tags: []string{}},
+		Labels: map[string]string{"k": "v"},
+	}
+	if err := m.SetObject("obj", in, 0); err != nil {
+		t.Fatal(err)
+	}
+	if it, _ := srv.item("obj"); it.flags != FlagJSON {
+		t.Fatalf("stored flags = %d, want FlagJSON", it.flags)
+	}
+	var out testObject
+	if err := m.GetObject("obj", &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("GetObject = %+v, want %+v", out, in)
+	}
+
+	if err := m.SetObject("list", []int{1, 2, 3}, 0); err != nil {
+		t.Fatal(err)
+	}
+	var list []int
+	if err := m.GetObject("list", &list); err != nil || !reflect.DeepEqual(list, []int{1, 2, 3}) {
+		t.Fatalf("GetObject of a slice = %v, %v", list, err)
+	}
+}
+
+func TestGetObjectRejectsOtherEncodings(t *testing.T) {
+	m, srv, _ := newTestClient(t)
+	srv.put("plain", `{"name":"x"}`, 0)
+	var out testObject
+	if err := m.GetObject("plain", &out); err == nil {
+		t.Fatal("GetObject decoded a value without the json flag")
+	}
+	if err := m.GetObject("missing", &out); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetObject of a miss = %v, want ErrNotFound", err)
+	}
+	if err := m.SetObject("bad", make(chan int), 0); err == nil {
+		t.Fatal("SetObject marshaled a channel")
+	}
+}
@@ -0,0 +1,23 @@
+package memcached
+
+import (
+	"strings"
+	"time"
+)
+
+type Observer interface {
+	ObserveOp(op string, duration time.Duration, err error)
+}
+
+func WithObserver(observer Observer) Option {
+	return func(m *Memcached) {
+		m.observer = observer
+	}
+}
+
+func verbOf(cmd string) string {
+	if i := strings.IndexAny(cmd, " \r"); i >= 0 {
+		return cmd[:i]
+	}
+	return cmd
+}
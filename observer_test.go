@@ -0,0 +1,70 @@
+package memcached
+
+import (
+	"errors"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type observation struct {
+	op       string
+	duration time.Duration
+	err      error
+}
+
+type recordingObserver struct {
+	mu  sync.Mutex
+	ops []observation
+}
+
+func (o *recordingObserver) ObserveOp(op string, duration time.Duration, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.ops = append(o.ops, observation{op: op, duration: duration, err: err})
+}
+
+func (o *recordingObserver) observed() []observation {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]observation(nil), o.ops...)
+}
+
+func TestObserver(t *testing.T) {
+	observer := &recordingObserver{}
+	m, srv, _ := newTestClient(t, WithObserver(observer))
+	srv.put("text", "abc", 0)
+
+	m.Set("k", "v", 0)
+	m.Get("missing")
+	m.Incr("text", 1)
+	m.Set("bad key", "v", 0)
+
+	down := newScriptTransport()
+	down.connectErrs = []error{syscall.ECONNREFUSED, syscall.ECONNREFUSED}
+	unreachable := NewMemcachedWithTransport(down, WithObserver(observer))
+	unreachable.Delete("k")
+
+	want := []struct {
+		op  string
+		err error
+	}{
+		{"set", nil},
+		{"get", nil},
+		{"incr", ErrServerError},
+		{"delete", ErrUnreachable},
+	}
+	got := observer.observed()
+	if len(got) != len(want) {
+		t.Fatalf("%d observations, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i].op != w.op || !errors.Is(got[i].err, w.err) || (w.err == nil) != (got[i].err == nil) {
+			t.Errorf("observation %d = %s %v, want %s %v", i, got[i].op, got[i].err, w.op, w.err)
+		}
+		if got[i].duration < 0 {
+			t.Errorf("observation %d has a negative duration", i)
+		}
+	}
+}
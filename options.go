@@ -0,0 +1,107 @@
+package memcached
+
+import (
+	"sync"
+	"time"
+)
+
+type Option func(m *Memcached)
+
+func WithCompression(threshold int) Option {
+	return func(m *Memcached) {
+		m.compressThreshold = threshold
+	}
+}
+
+func WithPrefix(prefix string) Option {
+	return func(m *Memcached) {
+		m.prefix = prefix
+	}
+}
+
+func WithMaxValueSize(size int) Option {
+	return func(m *Memcached) {
+		m.maxValueSize = size
+	}
+}
+
+func WithMaxKeyLength(length int) Option {
+	return func(m *Memcached) {
+		m.maxKeyLength = length
+	}
+}
+
+// WithMaxGetLine limits the length of a multi-key get command line; longer
+// key lists are fetched with several commands.
+func WithMaxGetLine(length int) Option {
+	return func(m *Memcached) {
+		m.maxGetLine = length
+	}
+}
+
+func WithClock(now func() time.Time) Option {
+	return func(m *Memcached) {
+		m.now = now
+	}
+}
+
+func WithDefaultTTL(ttl TTL) Option {
+	return func(m *Memcached) {
+		m.defaultTTL = ttl
+	}
+}
+
+// WithRandom replaces rand.Int63n as the source of TTL jitter.
+func WithRandom(random func(n int64) int64) Option {
+	return func(m *Memcached) {
+		m.random = random
+	}
+}
+
+// WithBestEffort turns connection, protocol and server errors into a miss
+// on reads and a silently lost write on writes, logging them to the
+// logger. Invalid arguments and replies such as ErrNotStored still fail.
+func WithBestEffort(enabled bool) Option {
+	return func(m *Memcached) {
+		m.bestEffortMode = enabled
+	}
+}
+
+// WithLenient accepts replies ending in a bare "\n" or carrying trailing
+// whitespace, as sent by some proxies.
+func WithLenient(enabled bool) Option {
+	return func(m *Memcached) {
+		m.lenient = enabled
+	}
+}
+
+// WithBufferPool reads values into *[]byte buffers from pool instead of
+// allocating one per value. Only TransportSocket can read into a buffer, so
+// TransportUDP and custom transports keep allocating through ReadN and ignore
+// the pool. Streamed values do not use it.
+func WithBufferPool(pool *sync.Pool) Option {
+	return func(m *Memcached) {
+		m.bufferPool = pool
+	}
+}
+
+// WithDialTimeout bounds connecting for socket transports.
+func WithDialTimeout(d time.Duration) Option {
+	return func(m *Memcached) {
+		m.dialTimeout = d
+	}
+}
+
+// WithCommandTimeout bounds the writes and reads of a single call. A call
+// that exceeds it returns ErrTimeout and closes its connection.
+func WithCommandTimeout(d time.Duration) Option {
+	return func(m *Memcached) {
+		m.commandTimeout = d
+	}
+}
+
+func WithIdleCheck(threshold time.Duration) Option {
+	return func(m *Memcached) {
+		m.idleCheck = threshold
+	}
+}
@@ -0,0 +1,200 @@
+package memcached
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type Result struct {
+	Key   Key
+	Value string
+	Found bool
+	Err   error
+}
+
+type Pipeline struct {
+	m   *Memcached
+	ops []pipelineOp
+}
+
+type pipelineOp struct {
+	verb string
+	key  Key
+	cmd  string
+	err  error
+}
+
+func (m *Memcached) Pipeline() *Pipeline {
+	return &Pipeline{m: m}
+}
+
+func (p *Pipeline) Set(key Key, value string, ttl TTL) {
+	cmd, err := p.m.storeCommand("set", key, value, 0, ttl, "")
+	p.ops = append(p.ops, pipelineOp{verb: "set", key: key, cmd: cmd, err: err})
+}
+
+func (p *Pipeline) Delete(key Key) {
+	full, err := p.m.validKey(key)
+	p.ops = append(p.ops, pipelineOp{verb: "delete", key: key, cmd: fmt.Sprintf("delete %s", full), err: err})
+}
+
+func (p *Pipeline) Touch(key Key, ttl TTL) {
+	full, err := p.m.validKey(key)
+	if err == nil {
+		err = ttl.isValid()
+	}
+	p.ops = append(p.ops, pipelineOp{verb: "touch", key: key, cmd: fmt.Sprintf("touch %s %d", full, ttl), err: err})
+}
+
+func (p *Pipeline) Get(key Key) {
+	full, err := p.m.validKey(key)
+	p.ops = append(p.ops, pipelineOp{verb: "get", key: key, cmd: fmt.Sprintf("get %s", full), err: err})
+}
+
+func (p *Pipeline) Execute() ([]Result, error) {
+	ops := p.ops
+	p.ops = nil
+
+	results := make([]Result, len(ops))
+	var cmds []string
+	for i, op := range ops {
+		results[i] = Result{Key: op.key, Err: op.err}
+		if op.err == nil {
+			cmds = append(cmds, op.cmd)
+		}
+	}
+	if len(cmds) == 0 {
+		return results, nil
+	}
+
+	err := p.m.exec(context.Background(), "pipeline", func(t Transport) error {
+		reconcileErr := p.m.reconcile(t)
+		if reconcileErr != nil {
+			return reconcileErr
+		}
+		writeErr := p.m.write(t, strings.Join(cmds, "\r\n"))
+		if writeErr != nil {
+			return writeErr
+		}
+		for i, op := range ops {
+			if op.err != nil {
+				continue
+			}
+			readErr := p.m.readResult(t, op, &results[i])
+			if readErr != nil {
+				return readErr
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+func (m *Memcached) readResult(t Transport, op pipelineOp, result *Result) error {
+	line, err := m.readLine(t)
+	if err != nil {
+		return err
+	}
+	result.Err = replyError(op.cmd, line)
+	if result.Err != nil {
+		return nil
+	}
+
+	switch op.verb {
+	case "set":
+		result.Err = storeReply(line)
+	case "delete":
+		if line == "NOT_FOUND\r\n" {
+			result.Err = ErrNotFound
+		} else if line != "DELETED\r\n" {
+			result.Err = fmt.Errorf("delete failed: %q\n", line)
+		}
+	case "touch":
+		if line == "NOT_FOUND\r\n" {
+			result.Err = ErrNotFound
+		} else if line != "TOUCHED\r\n" {
+			result.Err = fmt.Errorf("touch failed: %q\n", line)
+		}
+	case "get":
+		for line != "END\r\n" {
+			it, bytes, err := parseValueHeader(line)
+			if err != nil {
+				return err
+			}
+			it.value, err = m.readBody(t, bytes)
+			if err != nil {
+				return err
+			}
+			result.Value, _, result.Err = decodeValue(it.value, it.flags)
+			result.Found = result.Err == nil
+
+			line, err = m.readLine(t)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Memcached) DeleteMulti(keys []Key) (deleted []Key, err error) {
+	for _, key := range keys {
+		_, validKeyErr := m.validKey(key)
+		if validKeyErr != nil {
+			return nil, validKeyErr
+		}
+	}
+
+	p := m.Pipeline()
+	for _, key := range keys {
+		p.Delete(key)
+	}
+	results, err := p.Execute()
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		if result.Err == nil {
+			deleted = append(deleted, result.Key)
+			continue
+		}
+		if !errors.Is(result.Err, ErrNotFound) {
+			return deleted, result.Err
+		}
+	}
+	return deleted, nil
+}
+
+func (m *Memcached) TouchMulti(keys []Key, ttl TTL) (touched []Key, err error) {
+	for _, key := range keys {
+		_, validKeyErr := m.validKey(key)
+		if validKeyErr != nil {
+			return nil, validKeyErr
+		}
+	}
+	validTtlErr := ttl.isValid()
+	if validTtlErr != nil {
+		return nil, validTtlErr
+	}
+
+	p := m.Pipeline()
+	for _, key := range keys {
+		p.Touch(key, ttl)
+	}
+	results, err := p.Execute()
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		if result.Err == nil {
+			touched = append(touched, result.Key)
+			continue
+		}
+		if !errors.Is(result.Err, ErrNotFound) {
+			return touched, result.Err
+		}
+	}
+	return touched, nil
+}
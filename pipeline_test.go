@@ -0,0 +1,134 @@
+package memcached
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestPipelineOrderedResults(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("c", "old", 0)
+	p := m.Pipeline()
+	p.Set("a", "1", 0)
+	p.Set("b", "2", 0)
+	p.Set("c", "3", 0)
+	p.Delete("missing")
+	p.Get("b")
+	results, err := p.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("%d results, want 5", len(results))
+	}
+	for i, key := range []Key{"a", "b", "c"} {
+		if results[i].Key != key || results[i].Err != nil {
+			t.Fatalf("result %d = %+v, want a stored %q", i, results[i], key)
+		}
+	}
+	if !errors.Is(results[3].Err, ErrNotFound) {
+		t.Fatalf("delete result = %+v, want ErrNotFound", results[3])
+	}
+	if r := results[4]; !r.Found || r.Value != "2" {
+		t.Fatalf("get result = %+v", r)
+	}
+	want := "set a 0 0 1\r\n1\r\nset b 0 0 1\r\n2\r\nset c 0 0 1\r\n3\r\ndelete missing\r\nget b\r\n"
+	if got := tr.written.String(); got != want {
+		t.Fatalf("written %q, want %q", got, want)
+	}
+	if it, _ := srv.item("c"); string(it.value) != "3" {
+		t.Fatalf("c = %q", it.value)
+	}
+}
+
+func TestPipelineClientErrorMidBatch(t *testing.T) {
+	tr := newScriptTransport("STORED\r\nCLIENT_ERROR bad data chunk\r\nSTORED\r\nDELETED\r\n")
+	p := NewMemcachedWithTransport(tr).Pipeline()
+	p.Set("a", "1", 0)
+	p.Set("b", "2", 0)
+	p.Set("c", "3", 0)
+	p.Delete("a")
+	results, err := p.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var serverErr *ServerError
+	if results[0].Err != nil || !errors.As(results[1].Err, &serverErr) || results[2].Err != nil || results[3].Err != nil {
+		t.Fatalf("results = %+v, want only the second to fail", results)
+	}
+}
+
+func TestPipelineInvalidKeyIsNotSent(t *testing.T) {
+	m, _, tr := newTestClient(t)
+	p := m.Pipeline()
+	p.Set("bad key", "1", 0)
+	p.Set("ok", "2", 0)
+	results, err := p.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(results[0].Err, ErrInvalidArgument) || results[1].Err != nil {
+		t.Fatalf("results = %+v", results)
+	}
+	if got := tr.written.String(); got != "set ok 0 0 1\r\n2\r\n" {
+		t.Fatalf("written %q", got)
+	}
+}
+
+func TestDeleteMulti(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("a", "1", 0)
+	srv.put("c", "3", 0)
+	deleted, err := m.DeleteMulti([]Key{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(deleted) != "[a c]" {
+		t.Fatalf("DeleteMulti = %q, want [a c]", deleted)
+	}
+	if _, found := srv.item("a"); found {
+		t.Fatal("a was not deleted")
+	}
+	if got := tr.written.String(); got != "delete a\r\ndelete b\r\ndelete c\r\n" {
+		t.Fatalf("written %q, want one pipelined write", got)
+	}
+
+	tr.written.Reset()
+	if _, err := m.DeleteMulti([]Key{"c", "bad key"}); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("DeleteMulti with an invalid key = %v", err)
+	}
+	if tr.written.Len() != 0 {
+		t.Fatalf("DeleteMulti with an invalid key sent %q", tr.written.String())
+	}
+}
+
+func TestTouchMulti(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("a", "1", 0)
+	srv.put("c", "3", 0)
+	touched, err := m.TouchMulti([]Key{"a", "b", "c"}, 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(touched) != "[a c]" {
+		t.Fatalf("TouchMulti = %q, want [a c]", touched)
+	}
+	if it, _ := srv.item("a"); it.exp == 0 {
+		t.Fatal("a was not touched")
+	}
+	if got := tr.written.String(); got != "touch a 600\r\ntouch b 600\r\ntouch c 600\r\n" {
+		t.Fatalf("written %q, want one pipelined write", got)
+	}
+
+	tr.written.Reset()
+	if _, err := m.TouchMulti([]Key{"a", "bad key"}, 600); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("TouchMulti with an invalid key = %v", err)
+	}
+	if _, err := m.TouchMulti([]Key{"a"}, -1); err == nil {
+		t.Fatalf("TouchMulti with an invalid ttl = %v", err)
+	}
+	if tr.written.Len() != 0 {
+		t.Fatalf("TouchMulti with invalid input sent %q", tr.written.String())
+	}
+}
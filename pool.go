@@ -0,0 +1,80 @@
+package memcached
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type pool struct {
+	factory func() Transport
+	slots   chan struct{}
+	mu      sync.Mutex
+	idle    []idleTransport
+}
+
+type idleTransport struct {
+	transport Transport
+	since     time.Time
+}
+
+func newPool(maxConns int, factory func() Transport) *pool {
+	return &pool{factory: factory, slots: make(chan struct{}, maxConns)}
+}
+
+func (p *pool) get(ctx context.Context) (Transport, time.Duration, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n := len(p.idle); n > 0 {
+		it := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		return it.transport, time.Since(it.since), nil
+	}
+	return p.factory(), 0, nil
+}
+
+func (p *pool) put(t Transport, broken bool) {
+	if broken {
+		t.Close()
+	} else {
+		p.mu.Lock()
+		p.idle = append(p.idle, idleTransport{transport: t, since: time.Now()})
+		p.mu.Unlock()
+	}
+	<-p.slots
+}
+
+// takeIdle removes the idle transports from the pool, holding a slot for
+// each of them as get does, so that concurrent calls neither share them
+// nor dial past the cap. Every transport must be handed back with put.
+func (p *pool) takeIdle() []Transport {
+	var taken []Transport
+	for {
+		select {
+		case p.slots <- struct{}{}:
+		default:
+			return taken
+		}
+		p.mu.Lock()
+		n := len(p.idle)
+		if n == 0 {
+			p.mu.Unlock()
+			<-p.slots
+			return taken
+		}
+		taken = append(taken, p.idle[n-1].transport)
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+	}
+}
+
+func isReplyError(err error) bool {
+	return errors.Is(err, ErrNotStored) || errors.Is(err, ErrNotFound) || errors.Is(err, ErrCASConflict) ||
+		errors.Is(err, ErrBusy) || errors.Is(err, ErrBadClass)
+}
@@ -0,0 +1,74 @@
+package memcached
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolCapsConnections(t *testing.T) {
+	srv := newMemServer()
+	srv.delay = 50 * time.Millisecond
+	m, err := NewMemcachedPool("tcp", serveTCP(t, srv), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.Set("k", "v", 0); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := srv.conns.Load(); got != 3 {
+		t.Fatalf("connections = %d, want the cap of 3", got)
+	}
+	if elapsed := time.Since(start); elapsed < 2*srv.delay {
+		t.Fatalf("6 calls on 3 connections took %v, want callers to queue", elapsed)
+	}
+
+	if err := m.Set("k", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.conns.Load(); got != 3 {
+		t.Fatalf("connections after reuse = %d, want idle ones reused", got)
+	}
+}
+
+func TestPoolBlocksAtCap(t *testing.T) {
+	p := newPool(2, func() Transport { return newScriptTransport() })
+	a, _, _ := p.get(context.Background())
+	b, _, _ := p.get(context.Background())
+	if a == b {
+		t.Fatal("concurrent callers share a transport")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, err := p.get(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("get at the cap = %v, want it to block", err)
+	}
+
+	p.put(a, false)
+	if c, _, _ := p.get(context.Background()); c != a {
+		t.Fatal("idle transport was not reused")
+	}
+	p.put(b, true)
+	if d, _, _ := p.get(context.Background()); d == b {
+		t.Fatal("broken transport was reused")
+	}
+}
+
+func TestNewMemcachedPoolValidates(t *testing.T) {
+	if _, err := NewMemcachedPool("tcp", "127.0.0.1:11211", 0); err == nil {
+		t.Fatal("NewMemcachedPool accepted a pool size of 0")
+	}
+}
@@ -0,0 +1,42 @@
+package memcached
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RawCommand sends a single command line and returns the first reply line
+// without its terminator.
+func (m *Memcached) RawCommand(cmd string) (string, error) {
+	validCmdErr := validRawCommand(cmd)
+	if validCmdErr != nil {
+		return "", validCmdErr
+	}
+
+	resp, err := m.command(cmd)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(resp, "\r\n"), nil
+}
+
+// RawCommandMultiline sends a single command line and returns the reply
+// lines read up to, but not including, the terminator line.
+func (m *Memcached) RawCommandMultiline(cmd string, terminator string) ([]string, error) {
+	validCmdErr := validRawCommand(cmd)
+	if validCmdErr != nil {
+		return nil, validCmdErr
+	}
+	if terminator == "" || strings.ContainsAny(terminator, "\r\n") {
+		return nil, fmt.Errorf("invalid terminator: %q: %w", terminator, ErrInvalidArgument)
+	}
+
+	return m.commandMultiline(cmd, terminator)
+}
+
+func validRawCommand(cmd string) error {
+	if strings.TrimSpace(cmd) == "" || strings.ContainsAny(cmd, "\r\n") {
+		return fmt.Errorf("invalid command: %q: %w", cmd, ErrInvalidArgument)
+	}
+	return nil
+}
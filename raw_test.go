@@ -0,0 +1,48 @@
+package memcached
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRawCommand(t *testing.T) {
+	tr := newScriptTransport("OK\r\n")
+	resp, err := NewMemcachedWithTransport(tr).RawCommand("cache_memlimit 128")
+	if err != nil || resp != "OK" {
+		t.Fatalf("RawCommand = %q, %v", resp, err)
+	}
+	if got := tr.written.String(); got != "cache_memlimit 128\r\n" {
+		t.Fatalf("written %q", got)
+	}
+}
+
+func TestRawCommandMultiline(t *testing.T) {
+	tr := newScriptTransport("key=a exp=-1 la=1 cas=1 fetch=no cls=1 size=64\r\nkey=b exp=-1 la=1 cas=2 fetch=no cls=1 size=64\r\nEND\r\n")
+	lines, err := NewMemcachedWithTransport(tr).RawCommandMultiline("lru_crawler metadump all", "END")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0] != "key=a exp=-1 la=1 cas=1 fetch=no cls=1 size=64" {
+		t.Fatalf("RawCommandMultiline = %q", lines)
+	}
+}
+
+func TestRawCommandRejectsInjection(t *testing.T) {
+	m, _, tr := newTestClient(t)
+	for _, cmd := range []string{"", "  ", "version\r\nflush_all", "get a\nget b"} {
+		if _, err := m.RawCommand(cmd); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("RawCommand(%q) = %v, want ErrInvalidArgument", cmd, err)
+		}
+	}
+	for _, terminator := range []string{"", "END\r\n"} {
+		if _, err := m.RawCommandMultiline("stats", terminator); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("RawCommandMultiline with terminator %q = %v", terminator, err)
+		}
+	}
+	if tr.written.Len() != 0 {
+		t.Fatalf("rejected commands were sent: %q", tr.written.String())
+	}
+	if _, err := m.RawCommand("bogus"); !errors.Is(err, ErrServerError) {
+		t.Fatalf("RawCommand of an unknown command = %v", err)
+	}
+}
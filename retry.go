@@ -0,0 +1,49 @@
+package memcached
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(m *Memcached) {
+		m.retries = attempts
+		m.retryBackoff = backoff
+	}
+}
+
+func (m *Memcached) retryAttempts() int {
+	if m.retries > 0 {
+		return m.retries
+	}
+	return 2
+}
+
+// retryable only repeats a command the server has seen when doing so
+// twice is harmless, so incr, append, cas and the like are applied once.
+func (m *Memcached) retryable(cmd string, sent bool, err error) bool {
+	if !isConnError(err) && !(m.retries > 0 && errors.Is(err, ErrUnreachable)) {
+		return false
+	}
+	return !sent || idempotentVerbs[verbOf(cmd)]
+}
+
+var idempotentVerbs = map[string]bool{
+	"get": true, "gets": true, "gat": true, "gats": true, "touch": true,
+	"mg": true, "mn": true, "version": true, "stats": true,
+}
+
+func (m *Memcached) retryWait(ctx context.Context, attempt int) error {
+	if m.retryBackoff <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(m.retryBackoff << (attempt - 1))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,85 @@
+package memcached
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryDialFailures(t *testing.T) {
+	m, srv, tr := newTestClient(t, WithRetry(3, time.Millisecond))
+	tr.connectErrs = []error{syscall.ECONNREFUSED, syscall.ECONNREFUSED}
+	if err := m.Set("k", "v", 0); err != nil {
+		t.Fatalf("Set after two dial failures = %v", err)
+	}
+	if tr.connects != 1 || len(tr.connectErrs) != 0 {
+		t.Fatalf("connects = %d, %d dial failures left", tr.connects, len(tr.connectErrs))
+	}
+	if _, found := srv.item("k"); !found {
+		t.Fatal("value not stored")
+	}
+
+	tr.Close()
+	tr.connectErrs = []error{syscall.ECONNREFUSED, syscall.ECONNREFUSED, syscall.ECONNREFUSED}
+	if err := m.Set("k", "v", 0); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Set after three dial failures = %v, want ErrUnreachable", err)
+	}
+}
+
+func TestRetryDefaultSkipsDialFailures(t *testing.T) {
+	m, _, tr := newTestClient(t)
+	tr.connectErrs = []error{syscall.ECONNREFUSED}
+	if err := m.Set("k", "v", 0); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Set without WithRetry after a dial failure = %v", err)
+	}
+}
+
+func TestRetryOnlyIdempotentAfterWrite(t *testing.T) {
+	m, srv, tr := newTestClient(t, WithRetry(3, 0))
+	srv.put("n", "1", 0)
+	srv.put("k", "v", 0)
+
+	tr.readErrs = []error{io.EOF, io.EOF}
+	if value, err := m.Get("k"); err != nil || value != "v" {
+		t.Fatalf("Get after two failed reads = %q, %v", value, err)
+	}
+
+	for _, call := range []func() error{
+		func() error { _, err := m.Incr("n", 1); return err },
+		func() error { return m.Append("k", "x") },
+		func() error { return m.Cas("k", "w", 0, 1) },
+		func() error { _, err := m.MetaSet("k", "w", ""); return err },
+	} {
+		tr.readErrs = []error{syscall.ECONNRESET}
+		if err := call(); !errors.Is(err, syscall.ECONNRESET) {
+			t.Fatalf("call after a failed read = %v, want it not retried", err)
+		}
+	}
+	if it, _ := srv.item("n"); string(it.value) != "2" {
+		t.Fatalf("counter = %q, want incr applied once", it.value)
+	}
+	received := strings.Join(srv.received(), "\n")
+	for _, verb := range []string{"incr ", "append ", "cas ", "ms "} {
+		if got := strings.Count(received, verb); got != 1 {
+			t.Fatalf("%q sent %d times", verb, got)
+		}
+	}
+}
+
+func TestRetryHonoursContext(t *testing.T) {
+	m, _, tr := newTestClient(t, WithRetry(5, 100*time.Millisecond))
+	tr.connectErrs = []error{syscall.ECONNREFUSED, syscall.ECONNREFUSED}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := m.SetContext(ctx, "k", "v", 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("SetContext during backoff = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 90*time.Millisecond {
+		t.Fatalf("backoff ignored the deadline: %v", elapsed)
+	}
+}
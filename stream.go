@@ -0,0 +1,225 @@
+package memcached
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// GetStream keeps its connection out of the pool until the returned reader
+// is closed; closing it early discards the rest of the value. The command
+// timeout and the observer cover the request up to the value header.
+func (m *Memcached) GetStream(key Key) (io.ReadCloser, error) {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return nil, validKeyErr
+	}
+
+	var stream io.ReadCloser
+	err := m.execHeld(context.Background(), "get", func(t Transport) (bool, error) {
+		header, err := m.request(context.Background(), t, fmt.Sprintf("get %s", key))
+		if err != nil {
+			return false, err
+		}
+		if header == "END\r\n" {
+			return false, ErrNotFound
+		}
+		it, bytes, err := parseValueHeader(header)
+		if err != nil {
+			return false, err
+		}
+
+		s := &valueStream{m: m, t: t, remaining: bytes}
+		if it.flags&FlagCompressed == 0 {
+			stream = s
+			return true, nil
+		}
+		r, err := gzip.NewReader(s)
+		if err != nil {
+			s.Close()
+			return true, fmt.Errorf("cannot decompress value: %q\n", err)
+		}
+		stream = &compressedStream{Reader: r, s: s}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// SetStream copies exactly size bytes from r without compressing them. A
+// reader that ends early leaves the command unfinished, so the connection
+// is closed.
+func (m *Memcached) SetStream(key Key, r io.Reader, size int, ttl TTL) error {
+	key, validKeyErr := m.validKey(key)
+	if validKeyErr != nil {
+		return validKeyErr
+	}
+	validTtlErr := ttl.isValid()
+	if validTtlErr != nil {
+		return validTtlErr
+	}
+	if size < 0 {
+		return fmt.Errorf("invalid value size: %d: %w", size, ErrInvalidArgument)
+	}
+	if size > m.maxValueSize {
+		return fmt.Errorf("value of %d bytes exceeds %d: %w", size, m.maxValueSize, ErrValueTooLarge)
+	}
+
+	var resp string
+	err := m.exec(context.Background(), "set", func(t Transport) error {
+		reconcileErr := m.reconcile(t)
+		if reconcileErr != nil {
+			return reconcileErr
+		}
+		writeErr := m.write(t, fmt.Sprintf("set %s 0 %d %d", key, ttl, size))
+		if writeErr != nil {
+			return writeErr
+		}
+		_, copyErr := io.CopyN(transportWriter{t}, r, int64(size))
+		if copyErr == nil {
+			copyErr = t.Write("\r\n")
+		}
+		if copyErr == nil {
+			copyErr = t.Flush()
+		}
+		if copyErr != nil {
+			t.Close()
+			if errors.Is(copyErr, io.EOF) {
+				return fmt.Errorf("value is shorter than %d bytes: %w", size, io.ErrUnexpectedEOF)
+			}
+			return fmt.Errorf("write error: %w\n", copyErr)
+		}
+		var err error
+		resp, err = m.readLine(t)
+		if err != nil {
+			return err
+		}
+		return replyError("set", resp)
+	})
+	if err != nil {
+		return err
+	}
+	if resp != "STORED\r\n" {
+		return fmt.Errorf("value is not stored: %q: %w", resp, ErrNotStored)
+	}
+	return nil
+}
+
+type transportWriter struct {
+	t Transport
+}
+
+func (w transportWriter) Write(p []byte) (int, error) {
+	err := w.t.Write(string(p))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+type valueStream struct {
+	m         *Memcached
+	t         Transport
+	remaining int
+	err       error
+	closed    bool
+}
+
+func (s *valueStream) Read(p []byte) (int, error) {
+	if s.closed {
+		return 0, fmt.Errorf("read on closed stream\n")
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.remaining == 0 {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(p) > s.remaining {
+		p = p[:s.remaining]
+	}
+	data, err := s.t.ReadN(len(p))
+	if err != nil {
+		s.err = err
+		return 0, err
+	}
+	n := copy(p, data)
+	s.remaining -= n
+	return n, nil
+}
+
+func (s *valueStream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	err := s.err
+	if err == nil {
+		err = s.finish()
+	}
+	if err != nil {
+		s.m.counters.errors.Add(1)
+	}
+	s.m.release(s.t, err)
+	return err
+}
+
+func (s *valueStream) finish() error {
+	for s.remaining > 0 {
+		chunk := 32 * 1024
+		if chunk > s.remaining {
+			chunk = s.remaining
+		}
+		data, err := s.t.ReadN(chunk)
+		if err != nil {
+			return err
+		}
+		s.remaining -= len(data)
+	}
+	err := s.readTerminator()
+	if err != nil {
+		return err
+	}
+	line, err := s.m.readLine(s.t)
+	if err != nil {
+		return err
+	}
+	if line != "END\r\n" {
+		return &ProtocolError{Stage: "terminator", Line: line}
+	}
+	return nil
+}
+
+// readTerminator reads the "\r\n" after the value without going through
+// readBody, so that a stream never draws on the WithBufferPool pool.
+func (s *valueStream) readTerminator() error {
+	if s.m.lenient {
+		_, err := s.m.readBodyLenient(s.t, 0)
+		return err
+	}
+	end, err := s.t.ReadN(2)
+	if err != nil {
+		return err
+	}
+	if string(end) != "\r\n" {
+		return &ProtocolError{Stage: "terminator", Line: string(end)}
+	}
+	return nil
+}
+
+type compressedStream struct {
+	*gzip.Reader
+	s *valueStream
+}
+
+func (c *compressedStream) Close() error {
+	c.Reader.Close()
+	return c.s.Close()
+}
@@ -0,0 +1,189 @@
+package memcached
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetStream(t *testing.T) {
+	m, srv := newTCPClient(t)
+	value := strings.Repeat("0123456789abcdef", 64*1024)
+	srv.put("big", value, 0)
+	srv.chunk = 64 * 1024
+
+	r, err := m.GetStream("big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got strings.Builder
+	buf := make([]byte, 1000)
+	for {
+		n, err := r.Read(buf)
+		got.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got.String() != value {
+		t.Fatalf("streamed %d bytes, want %d", got.Len(), len(value))
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(buf); err == nil {
+		t.Fatal("Read after Close succeeded")
+	}
+
+	srv.put("small", "x", 0)
+	if v, err := m.Get("small"); err != nil || v != "x" || srv.conns.Load() != 1 {
+		t.Fatalf("Get after a stream = %q, %v on %d connections", v, err, srv.conns.Load())
+	}
+}
+
+func TestGetStreamEarlyClose(t *testing.T) {
+	m, srv, _ := newTestClient(t)
+	srv.put("k", "hello world", 0)
+	srv.put("next", "n", 0)
+	r, err := m.GetStream("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(r, head); err != nil || string(head) != "hello" {
+		t.Fatalf("read %q, %v", head, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("early Close = %v", err)
+	}
+	if v, err := m.Get("next"); err != nil || v != "n" {
+		t.Fatalf("Get after an early Close = %q, %v", v, err)
+	}
+	if _, err := m.GetStream("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetStream of a miss = %v, want ErrNotFound", err)
+	}
+}
+
+func TestGetStreamHoldsConnection(t *testing.T) {
+	m, srv, _ := newTestClient(t)
+	srv.put("k", "v", 0)
+	r, err := m.GetStream("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := m.GetContext(ctx, "k"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get while a stream is open = %v, want it to wait for the connection", err)
+	}
+	r.Close()
+	if v, err := m.Get("k"); err != nil || v != "v" {
+		t.Fatalf("Get after Close = %q, %v", v, err)
+	}
+}
+
+func TestGetStreamCompressed(t *testing.T) {
+	m, _, _ := newTestClient(t, WithCompression(16))
+	value := strings.Repeat("compressible ", 100)
+	if err := m.Set("k", value, 0); err != nil {
+		t.Fatal(err)
+	}
+	r, err := m.GetStream("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil || string(data) != value {
+		t.Fatalf("compressed stream = %d bytes, %v", len(data), err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetStreamSharesCommandPath(t *testing.T) {
+	observer := &recordingObserver{}
+	m, srv, tr := newTestClient(t, WithObserver(observer), WithCommandTimeout(time.Second), WithIdleCheck(time.Nanosecond))
+	srv.put("k", "value", 0)
+	if err := m.Set("k", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	tr.written.Reset()
+	deadlines := len(tr.deadlines)
+
+	r, err := m.GetStream("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if got := tr.written.String(); got != "version\r\nget k\r\n" {
+		t.Fatalf("written %q, want the idle probe before the get", got)
+	}
+	if len(tr.deadlines) == deadlines {
+		t.Fatal("GetStream did not apply the command timeout")
+	}
+	if ops := observer.observed(); len(ops) != 2 || ops[1].op != "get" || ops[1].err != nil {
+		t.Fatalf("observations = %+v, want the get", ops)
+	}
+
+}
+
+func TestGetStreamCountsBrokenStreams(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	srv.put("k", "value", 0)
+	tr.readErrs = []error{nil, io.ErrUnexpectedEOF}
+	r, err := m.GetStream("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("read of a broken stream succeeded")
+	}
+	if err := r.Close(); err == nil {
+		t.Fatal("Close of a broken stream succeeded")
+	}
+	if tr.connected {
+		t.Fatal("a broken stream left its connection open")
+	}
+	if got := m.ConnStats().Errors; got != 1 {
+		t.Fatalf("ConnStats.Errors = %d, want the broken stream counted", got)
+	}
+}
+
+func TestSetStream(t *testing.T) {
+	m, srv, tr := newTestClient(t)
+	value := strings.Repeat("x", 100000)
+	if err := m.SetStream("k", strings.NewReader(value+"trailing"), len(value), 60); err != nil {
+		t.Fatal(err)
+	}
+	if it, _ := srv.item("k"); string(it.value) != value {
+		t.Fatalf("stored %d bytes, want %d", len(it.value), len(value))
+	}
+	if !strings.HasPrefix(tr.written.String(), "set k 0 60 100000\r\n") {
+		t.Fatalf("written %q", tr.written.String()[:32])
+	}
+
+	if err := m.SetStream("short", strings.NewReader("abc"), 10, 0); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("SetStream of a short reader = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if _, found := srv.item("short"); found {
+		t.Fatal("a short value was stored")
+	}
+	if v, err := m.Get("k"); err != nil || v != value {
+		t.Fatalf("Get after a short SetStream = %d bytes, %v", len(v), err)
+	}
+
+	if err := m.SetStream("k", strings.NewReader(""), -1, 0); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("SetStream with a negative size = %v", err)
+	}
+	if err := m.SetStream("k", strings.NewReader(""), defaultMaxValueSize+1, 0); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("SetStream over the value size = %v", err)
+	}
+}
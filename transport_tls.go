@@ -0,0 +1,23 @@
+package memcached
+
+import (
+	"crypto/tls"
+)
+
+type TransportTLS struct {
+	TransportSocket
+}
+
+func NewTransportTLS(address string, cfg *tls.Config) *TransportTLS {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	return &TransportTLS{TransportSocket{network: "tcp", address: address, tlsConfig: cfg}}
+}
+
+func NewMemcachedTLS(address string, cfg *tls.Config, opts ...Option) (*Memcached, error) {
+	factory := func() Transport {
+		return NewTransportTLS(address, cfg)
+	}
+	return newMemcached(address, 1, factory, opts), nil
+}
@@ -0,0 +1,85 @@
+package memcached
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// selfSignedCert returns a certificate for 127.0.0.1 and a pool trusting it.
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "memcached test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, roots
+}
+
+func serveTLS(t *testing.T, srv *memServer) (string, *x509.CertPool) {
+	t.Helper()
+	cert, roots := selfSignedCert(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveListener(t, ln, srv), roots
+}
+
+func TestTLSTransport(t *testing.T) {
+	srv := newMemServer()
+	addr, roots := serveTLS(t, srv)
+	m, err := NewMemcachedTLS(addr, &tls.Config{RootCAs: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err := m.Set("k", "secret\r\nvalue", 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := m.Get("k"); err != nil || value != "secret\r\nvalue" {
+		t.Fatalf("Get over TLS = %q, %v", value, err)
+	}
+}
+
+func TestTLSTransportVerifiesCertificate(t *testing.T) {
+	addr, _ := serveTLS(t, newMemServer())
+	untrusted, _ := NewMemcachedTLS(addr, nil)
+	defer untrusted.Close()
+	if err := untrusted.Set("k", "v", 0); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Set to an untrusted server = %v, want ErrUnreachable", err)
+	}
+
+	insecure, _ := NewMemcachedTLS(addr, &tls.Config{InsecureSkipVerify: true})
+	defer insecure.Close()
+	if err := insecure.Set("k", "v", 0); err != nil {
+		t.Fatalf("Set with verification disabled = %v", err)
+	}
+}
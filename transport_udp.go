@@ -0,0 +1,212 @@
+package memcached
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	udpHeaderLen      = 8
+	udpMaxDatagram    = 1400
+	udpDefaultTimeout = time.Second
+)
+
+type TransportUDP struct {
+	address   string
+	timeout   time.Duration
+	conn      net.Conn
+	requestID uint16
+	mu        sync.Mutex
+	deadline  time.Time
+	response  *bytes.Reader
+	counters  *connCounters
+	dialed    bool
+}
+
+func NewTransportUDP(address string) *TransportUDP {
+	return &TransportUDP{address: address, timeout: udpDefaultTimeout}
+}
+
+func NewMemcachedUDP(address string, opts ...Option) (*Memcached, error) {
+	factory := func() Transport {
+		return NewTransportUDP(address)
+	}
+	return newMemcached(address, 1, factory, opts), nil
+}
+
+func (t *TransportUDP) Connect() error {
+	if t.conn != nil {
+		return nil
+	}
+	conn, dialErr := net.Dial("udp", t.address)
+	if dialErr != nil {
+		return fmt.Errorf("cannot connect: %q: %w", dialErr, ErrUnreachable)
+	}
+	t.counters.dialed(t.dialed)
+	t.dialed = true
+	t.mu.Lock()
+	t.conn = conn
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *TransportUDP) Close() {
+	if t.conn == nil {
+		return
+	}
+	err := t.conn.Close()
+	t.mu.Lock()
+	t.conn = nil
+	t.mu.Unlock()
+	t.response = nil
+	if err != nil {
+		fmt.Println("cannot close connection: ", err)
+	}
+}
+
+func (t *TransportUDP) Write(data string) error {
+	if t.conn == nil {
+		return net.ErrClosed
+	}
+	if udpHeaderLen+len(data) > udpMaxDatagram {
+		return fmt.Errorf("request too large for udp: %d bytes\n", len(data))
+	}
+	t.requestID++
+	t.response = nil
+	_, err := t.conn.Write(append(encodeUDPHeader(t.requestID, 0, 1), data...))
+	if err == nil {
+		t.counters.written(len(data))
+	}
+	return err
+}
+
+// Flush is a no-op, every Write is sent as a datagram.
+func (t *TransportUDP) Flush() error {
+	return nil
+}
+
+func (t *TransportUDP) ReadLine() (string, error) {
+	readyErr := t.readReady()
+	if readyErr != nil {
+		return "", readyErr
+	}
+	var line []byte
+	for {
+		c, err := t.response.ReadByte()
+		if err != nil {
+			return "", io.ErrUnexpectedEOF
+		}
+		line = append(line, c)
+		if c == '\n' {
+			t.counters.read(len(line))
+			return string(line), nil
+		}
+	}
+}
+
+func (t *TransportUDP) ReadN(n int) ([]byte, error) {
+	readyErr := t.readReady()
+	if readyErr != nil {
+		return nil, readyErr
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(t.response, buf)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	t.counters.read(n)
+	return buf, nil
+}
+
+func (t *TransportUDP) readReady() error {
+	if t.response == nil || t.response.Len() == 0 {
+		return t.receive()
+	}
+	return nil
+}
+
+func (t *TransportUDP) setCounters(c *connCounters) {
+	t.counters = c
+}
+
+func (t *TransportUDP) SetDeadline(deadline time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deadline = deadline
+	if t.conn != nil {
+		_ = t.conn.SetReadDeadline(deadline)
+	}
+}
+
+func (t *TransportUDP) receive() error {
+	deadline := time.Now().Add(t.timeout)
+	t.mu.Lock()
+	if !t.deadline.IsZero() && t.deadline.Before(deadline) {
+		deadline = t.deadline
+	}
+	t.mu.Unlock()
+	deadlineErr := t.conn.SetReadDeadline(deadline)
+	if deadlineErr != nil {
+		return deadlineErr
+	}
+
+	datagrams := make(map[uint16][]byte)
+	total := -1
+	buf := make([]byte, 65536)
+	for total < 0 || len(datagrams) < total {
+		n, err := t.conn.Read(buf)
+		if err != nil {
+			if isTimeout(err) {
+				t.Close()
+				return ErrTimeout
+			}
+			return err
+		}
+		requestID, seq, count, err := decodeUDPHeader(buf[:n])
+		if err != nil {
+			return err
+		}
+		if requestID != t.requestID {
+			continue
+		}
+		total = int(count)
+		datagrams[seq] = append([]byte(nil), buf[udpHeaderLen:n]...)
+	}
+
+	var response []byte
+	for seq := 0; seq < total; seq++ {
+		part, ok := datagrams[uint16(seq)]
+		if !ok {
+			return fmt.Errorf("missing udp datagram: %d of %d\n", seq, total)
+		}
+		response = append(response, part...)
+	}
+	t.response = bytes.NewReader(response)
+	return nil
+}
+
+func encodeUDPHeader(requestID uint16, seq uint16, count uint16) []byte {
+	header := make([]byte, udpHeaderLen)
+	binary.BigEndian.PutUint16(header[0:2], requestID)
+	binary.BigEndian.PutUint16(header[2:4], seq)
+	binary.BigEndian.PutUint16(header[4:6], count)
+	return header
+}
+
+func decodeUDPHeader(datagram []byte) (requestID uint16, seq uint16, count uint16, err error) {
+	if len(datagram) < udpHeaderLen {
+		return 0, 0, 0, fmt.Errorf("cannot parse udp header: %q\n", datagram)
+	}
+	requestID = binary.BigEndian.Uint16(datagram[0:2])
+	seq = binary.BigEndian.Uint16(datagram[2:4])
+	count = binary.BigEndian.Uint16(datagram[4:6])
+	if count == 0 || seq >= count {
+		return 0, 0, 0, fmt.Errorf("cannot parse udp header: %q\n", datagram[:udpHeaderLen])
+	}
+	return requestID, seq, count, nil
+}
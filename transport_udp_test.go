@@ -0,0 +1,113 @@
+package memcached
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveUDP answers every datagram with the payloads reply returns, each one
+// sent as a datagram of its own after a frame header.
+func serveUDP(t *testing.T, reply func(request string) []string) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if _, _, _, err := decodeUDPHeader(buf[:n]); err != nil {
+				continue
+			}
+			for _, part := range reply(string(buf[udpHeaderLen:n])) {
+				conn.WriteTo([]byte(part), addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func udpDatagram(requestID uint16, seq uint16, count uint16, payload string) string {
+	return string(encodeUDPHeader(requestID, seq, count)) + payload
+}
+
+func TestUDPHeader(t *testing.T) {
+	header := encodeUDPHeader(0x0102, 3, 4)
+	if want := []byte{0x01, 0x02, 0x00, 0x03, 0x00, 0x04, 0x00, 0x00}; !bytes.Equal(header, want) {
+		t.Fatalf("header = % x, want % x", header, want)
+	}
+	requestID, seq, count, err := decodeUDPHeader(append(header, "data"...))
+	if err != nil || requestID != 0x0102 || seq != 3 || count != 4 {
+		t.Fatalf("decodeUDPHeader = %d, %d, %d, %v", requestID, seq, count, err)
+	}
+	for _, bad := range [][]byte{header[:7], encodeUDPHeader(1, 0, 0), encodeUDPHeader(1, 2, 2)} {
+		if _, _, _, err := decodeUDPHeader(bad); err == nil {
+			t.Errorf("decodeUDPHeader(% x) succeeded", bad)
+		}
+	}
+}
+
+func TestUDPGet(t *testing.T) {
+	addr := serveUDP(t, func(request string) []string {
+		if request != "get k\r\n" {
+			return []string{udpDatagram(1, 0, 1, "ERROR\r\n")}
+		}
+		return []string{udpDatagram(1, 0, 1, "VALUE k 0 5\r\nhello\r\nEND\r\n")}
+	})
+	m, err := NewMemcachedUDP(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if value, err := m.Get("k"); err != nil || value != "hello" {
+		t.Fatalf("Get over udp = %q, %v", value, err)
+	}
+}
+
+func TestUDPReassemblesBySequence(t *testing.T) {
+	addr := serveUDP(t, func(string) []string {
+		return []string{
+			udpDatagram(9, 0, 1, "VALUE stale 0 1\r\nx\r\nEND\r\n"),
+			udpDatagram(1, 2, 3, "ld\r\nEND\r\n"),
+			udpDatagram(1, 0, 3, "VALUE k 0 11\r\n"),
+			udpDatagram(1, 1, 3, "hello wor"),
+		}
+	})
+	m, _ := NewMemcachedUDP(addr)
+	defer m.Close()
+	if value, err := m.Get("k"); err != nil || value != "hello world" {
+		t.Fatalf("Get of a three datagram reply = %q, %v", value, err)
+	}
+}
+
+func TestUDPMissingDatagramTimesOut(t *testing.T) {
+	addr := serveUDP(t, func(string) []string {
+		return []string{udpDatagram(1, 0, 2, "VALUE k 0 11\r\n")}
+	})
+	tr := NewTransportUDP(addr)
+	tr.timeout = 50 * time.Millisecond
+	m := NewMemcachedWithTransport(tr)
+	defer m.Close()
+	if _, err := m.Get("k"); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Get with a lost datagram = %v, want ErrTimeout", err)
+	}
+}
+
+func TestUDPRejectsLargeRequests(t *testing.T) {
+	tr := NewTransportUDP(serveUDP(t, func(string) []string { return nil }))
+	if err := tr.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	if err := tr.Write(string(make([]byte, udpMaxDatagram))); err == nil {
+		t.Fatal("Write accepted a request over one datagram")
+	}
+}